@@ -0,0 +1,104 @@
+package execmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ClusterResGroup holds hosts which produced identical stdout, stderr and exit code.
+type ClusterResGroup struct {
+	Hosts    []string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Nodeset returns group hosts folded into a compact nodeset, e.g. web[01-20,22].
+func (g ClusterResGroup) Nodeset() string {
+	return FoldHosts(g.Hosts)
+}
+
+// AggregateResults groups hosts with identical outputs and exit codes.
+// The largest groups come first, groups of the same size keep the order of results.
+func AggregateResults(results []ClusterRes) []ClusterResGroup {
+	var groups []ClusterResGroup
+	index := map[string]int{}
+
+	for _, r := range results {
+		g := ClusterResGroup{
+			Stdout:   bufferString(r.Res.Stdout),
+			Stderr:   bufferString(r.Res.Stderr),
+			ExitCode: exitCode(r.Err),
+		}
+
+		key := fmt.Sprintf("%d\x00%s\x00%s", g.ExitCode, g.Stdout, g.Stderr)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, g)
+		}
+		groups[i].Hosts = append(groups[i].Hosts, r.Host)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+
+	return groups
+}
+
+// WriteAggregated renders groups as a report with one block per distinct output.
+func WriteAggregated(w io.Writer, groups []ClusterResGroup) error {
+	for _, g := range groups {
+		sep := strings.Repeat("-", 15)
+		header := fmt.Sprintf("%s\n%s (%d)\n%s\n", sep, colorStrong(g.Nodeset()), len(g.Hosts), sep)
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(w, ensureNewline(g.Stdout)); err != nil {
+			return err
+		}
+
+		for _, line := range splitLines(g.Stderr) {
+			if _, err := fmt.Fprintf(w, "%s%s\n", colorErr("@err "), line); err != nil {
+				return err
+			}
+		}
+
+		if g.ExitCode != 0 {
+			if _, err := fmt.Fprintf(w, "%s\n", colorErr(fmt.Sprintf("exit code: %d", g.ExitCode))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// bufferString is nil-safe Buffer.String() for results of hosts that failed to start.
+func bufferString(buf *bytes.Buffer) string {
+	if buf == nil {
+		return ""
+	}
+	return buf.String()
+}
+
+func ensureNewline(text string) string {
+	if text == "" || strings.HasSuffix(text, "\n") {
+		return text
+	}
+	return text + "\n"
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
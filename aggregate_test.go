@@ -0,0 +1,78 @@
+package execmd_test
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func newClusterRes(host, stdout, stderr string, err error) execmd.ClusterRes {
+	return execmd.ClusterRes{
+		Host: host,
+		Err:  err,
+		Res: execmd.CmdRes{
+			Stdout: bytes.NewBufferString(stdout),
+			Stderr: bytes.NewBufferString(stderr),
+		},
+	}
+}
+
+func TestFoldHosts(t *testing.T) {
+	tests := []struct {
+		hosts    []string
+		expected string
+	}{
+		{[]string{"web01"}, "web01"},
+		{[]string{"web01", "web02", "web03", "web05"}, "web[01-03,05]"},
+		{[]string{"web03", "web01", "web02", "web01"}, "web[01-03]"},
+		{[]string{"node9", "node10", "node11"}, "node[9-11]"},
+		{[]string{"web09", "web10", "web100"}, "web[09-10,100]"},
+		{[]string{"web1.dc1", "web2.dc1", "web3.dc1"}, "web[1-3].dc1"},
+		{[]string{"localhost", "db1", "db2"}, "localhost,db[1-2]"},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.1.1"}, "10.0.0.[1-2],10.0.1.1"},
+	}
+
+	for _, tt := range tests {
+		if got := execmd.FoldHosts(tt.hosts); got != tt.expected {
+			t.Errorf("FoldHosts(%v) = %s, expected %s", tt.hosts, got, tt.expected)
+		}
+	}
+}
+
+func TestAggregateResults(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+
+	results := []execmd.ClusterRes{
+		newClusterRes("web01", "5.10\n", "", nil),
+		newClusterRes("web02", "5.10\n", "", nil),
+		newClusterRes("web03", "5.15\n", "", nil),
+		newClusterRes("web04", "5.10\n", "", nil),
+		newClusterRes("web05", "5.10\n", "", exitErr),
+	}
+
+	groups := execmd.AggregateResults(results)
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 groups, got %d", len(groups))
+	}
+
+	if groups[0].Nodeset() != "web[01-02,04]" || groups[0].Stdout != "5.10\n" {
+		t.Errorf("Unexpected first group: %+v", groups[0])
+	}
+	if groups[1].Nodeset() != "web03" || groups[1].Stdout != "5.15\n" {
+		t.Errorf("Unexpected second group: %+v", groups[1])
+	}
+	if groups[2].Nodeset() != "web05" || groups[2].ExitCode != 3 {
+		t.Errorf("Unexpected third group: %+v", groups[2])
+	}
+
+	var out bytes.Buffer
+	if err := execmd.WriteAggregated(&out, groups); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "5.10\n") != 2 || !strings.Contains(out.String(), "exit code: 3") {
+		t.Errorf("Unexpected aggregated report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,258 @@
+package execmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is an audit log entry of an executed command: who ran it, when, where and what,
+// written when the command completes, see AuditSink.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// User is the local user who ran the command
+	User string `json:"user"`
+	// Host is the remote host of ssh commands, or the local host name
+	Host string `json:"host"`
+	// SSHUser is the remote user of ssh commands if it's set, ssh picks the user otherwise
+	SSHUser string `json:"ssh_user,omitempty"`
+	// BecomeUser is the user the command ran as with sudo, su or doas, see Become
+	BecomeUser string `json:"become_user,omitempty"`
+	// Command is the command with the secrets redacted, the remote command for ssh commands
+	Command    string `json:"command"`
+	Cwd        string `json:"cwd,omitempty"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// AuditSink writes audit records of executed commands, see Cmd.Audit.
+// It must be safe to write records from several goroutines.
+// A write error doesn't change the result of the command, FileAuditSink and SyslogAuditSink keep it for .Err().
+type AuditSink interface {
+	WriteAudit(rec AuditRecord) error
+}
+
+// AuditFunc is a function used as an AuditSink.
+type AuditFunc func(rec AuditRecord) error
+
+// WriteAudit calls f(rec).
+func (f AuditFunc) WriteAudit(rec AuditRecord) error {
+	return f(rec)
+}
+
+// secretPatterns match values of secret assignments and flags, e.g. DB_PASSWORD=... or --token ...
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)([\w.-]*(?:password|passwd|secret|token|api_?key)[\w.-]*=)('[^']*'|"[^"]*"|[^\s;&|]+)`),
+	regexp.MustCompile(`(?i)(--?[\w-]*(?:password|passwd|secret|token|api-?key)[\w-]*\s+)('[^']*'|"[^"]*"|[^\s;&|-][^\s;&|]*)`),
+}
+
+// redactCommand masks the secrets and the values of secret assignments and flags in the command
+func redactCommand(command string, secrets []string) string {
+	for _, secret := range secrets {
+		command = strings.Replace(command, secret, redactedText, -1)
+	}
+	for _, re := range secretPatterns {
+		command = re.ReplaceAllString(command, "${1}"+redactedText)
+	}
+
+	return command
+}
+
+// newAuditRecord returns the record of the command started now with the options, the host is empty for local commands
+func newAuditRecord(command string, opts startOptions) *AuditRecord {
+	rec := &AuditRecord{
+		Time:       time.Now(),
+		User:       auditUser(),
+		Host:       opts.host,
+		SSHUser:    opts.sshUser,
+		BecomeUser: opts.becomeUser,
+		Command:    redactCommand(command, opts.redact),
+		Cwd:        opts.auditCwd,
+	}
+
+	if rec.Host == "" {
+		rec.Host, _ = os.Hostname()
+		rec.Cwd, _ = os.Getwd()
+	}
+
+	return rec
+}
+
+// auditUser returns the name of the local user
+func auditUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// writeAudit completes the audit record of the process with the result and writes it to the sink,
+// the result of the command is kept on a write error
+func (p *Process) writeAudit() {
+	p.audit.ExitCode = p.Res.ExitCode
+	p.audit.DurationMs = p.Res.Duration.Milliseconds()
+	if p.waitErr != nil {
+		p.audit.Error = p.waitErr.Error()
+	}
+
+	p.auditSink.WriteAudit(*p.audit)
+}
+
+// FileAuditSink appends audit records to a file as JSON Lines, see OpenAuditFile.
+type FileAuditSink struct {
+	mu  sync.Mutex
+	f   *os.File
+	err error
+}
+
+// OpenAuditFile opens the append-only audit log file, creating it readable by the owner only.
+func OpenAuditFile(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{f: f}, nil
+}
+
+// WriteAudit appends the record as a JSON line.
+func (s *FileAuditSink) WriteAudit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a single write of the whole line keeps the records of several processes apart
+	if _, err = s.f.Write(append(data, '\n')); err != nil && s.err == nil {
+		s.err = err
+	}
+	return err
+}
+
+// Err returns the first error of writing a record.
+func (s *FileAuditSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Close closes the file.
+func (s *FileAuditSink) Close() error {
+	return s.f.Close()
+}
+
+// syslogSockets are the local syslog sockets tried by NewSyslogAuditSink
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogPriority is the authpriv.notice priority of audit records, as sudo logs commands
+const syslogPriority = 10<<3 | 5
+
+// SyslogAuditSink sends audit records as JSON messages to the local syslog daemon, see NewSyslogAuditSink.
+type SyslogAuditSink struct {
+	mu   sync.Mutex
+	addr string
+	tag  string
+	conn net.Conn
+	err  error
+}
+
+// NewSyslogAuditSink connects to the local syslog socket, /dev/log or another usual one if addr is empty.
+// The messages are tagged with the tag, "execmd" if it's empty.
+func NewSyslogAuditSink(addr, tag string) (*SyslogAuditSink, error) {
+	if tag == "" {
+		tag = "execmd"
+	}
+	s := &SyslogAuditSink{addr: addr, tag: tag}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect dials the syslog socket as a datagram or a stream socket
+func (s *SyslogAuditSink) connect() error {
+	addrs := syslogSockets
+	if s.addr != "" {
+		addrs = []string{s.addr}
+	}
+
+	var err error
+	for _, addr := range addrs {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.Dial(network, addr); err == nil {
+				s.conn = conn
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("failed to connect to syslog: %w", err)
+}
+
+// WriteAudit sends the record to syslog, reconnecting once if the daemon was restarted.
+func (s *SyslogAuditSink) WriteAudit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("<%d>%s %s[%d]: %s\n", syslogPriority, time.Now().Format(time.Stamp), s.tag, os.Getpid(), data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err = s.send(msg); err != nil && s.err == nil {
+		s.err = err
+	}
+	return err
+}
+
+// send writes the message to the connection, reconnecting if it fails
+func (s *SyslogAuditSink) send(msg string) error {
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	_, err := s.conn.Write([]byte(msg))
+	return err
+}
+
+// Err returns the first error of sending a record.
+func (s *SyslogAuditSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,191 @@
+package execmd_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+// auditLog collects the audit records
+type auditLog struct {
+	mu      sync.Mutex
+	records []execmd.AuditRecord
+}
+
+func (l *auditLog) WriteAudit(rec execmd.AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, rec)
+	return nil
+}
+
+func TestCmd_Audit(t *testing.T) {
+	log := &auditLog{}
+	cmd := execmd.NewCmd()
+	cmd.MuteCmd, cmd.MuteStdout, cmd.MuteStderr = true, true, true
+	cmd.Audit = log
+
+	started := time.Now()
+	cmd.Run("DB_PASSWORD=hunter2 mysql --token 's3cret' -u app; exit 3")
+	cmd.Run("true")
+
+	if len(log.records) != 2 {
+		t.Fatalf("Unexpected audit records: %+v", log.records)
+	}
+
+	rec := log.records[0]
+	hostname, _ := os.Hostname()
+	cwd, _ := os.Getwd()
+	if rec.Command != "DB_PASSWORD=******** mysql --token ******** -u app; exit 3" {
+		t.Errorf("Secrets are not redacted: %s", rec.Command)
+	}
+	if rec.Host != hostname || rec.Cwd != cwd || rec.User == "" || rec.ExitCode != 3 || rec.Error == "" {
+		t.Errorf("Unexpected audit record: %+v", rec)
+	}
+	if rec.Time.Before(started.Add(-time.Second)) || rec.Time.After(time.Now()) {
+		t.Errorf("Unexpected audit time: %s", rec.Time)
+	}
+	if log.records[1].Command != "true" || log.records[1].ExitCode != 0 || log.records[1].Error != "" {
+		t.Errorf("Unexpected audit record: %+v", log.records[1])
+	}
+
+	// commands which failed to start are not audited
+	cmd.ShellPath = "/no/such/shell"
+	if _, err := cmd.Run("true"); err == nil {
+		t.Error("Expected start error")
+	}
+	if len(log.records) != 2 {
+		t.Errorf("Not started command is audited: %+v", log.records[2:])
+	}
+
+	// a failed audit write keeps the result of the command
+	cmd = execmd.NewCmd()
+	cmd.MuteCmd, cmd.MuteStdout = true, true
+	cmd.Audit = execmd.AuditFunc(func(execmd.AuditRecord) error {
+		return errors.New("disk full")
+	})
+	if _, err := cmd.Run("true"); err != nil {
+		t.Errorf("Unexpected error of the audited command: %v", err)
+	}
+	if res, err := cmd.Run("exit 2"); err == nil || res.ExitCode != 2 {
+		t.Errorf("Expected the command error, got: %d, %v", res.ExitCode, err)
+	}
+}
+
+func TestSSHCmd_AuditUsers(t *testing.T) {
+	ssh, _ := fakeSSH(t)
+	log := &auditLog{}
+
+	srv := execmd.NewSSHCmd(dummyHost)
+	srv.SSHExecutable = ssh
+	srv.Cmd.MuteCmd = true
+	srv.User = "deploy"
+	srv.Become = &execmd.Become{User: "postgres"}
+	srv.Audit = log
+	if _, err := srv.Run("psql -c 'select 1'"); err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+
+	if len(log.records) != 1 || log.records[0].SSHUser != "deploy" || log.records[0].BecomeUser != "postgres" ||
+		log.records[0].Command != "psql -c 'select 1'" {
+		t.Errorf("Unexpected audit records: %+v", log.records)
+	}
+}
+
+func TestClusterSSHCmd_Audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := execmd.OpenAuditFile(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+	cluster.Cwd = "/tmp"
+	cluster.Audit = sink
+	captureOutput(t, func() {
+		cluster.Run("echo hi")
+	})
+	sink.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec execmd.AuditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Invalid audit record %q: %v", line, err)
+		}
+		if rec.Command != "echo hi" || rec.Cwd != "/tmp" || rec.ExitCode != 0 {
+			t.Errorf("Unexpected audit record: %+v", rec)
+		}
+		hosts[rec.Host] = true
+	}
+	if len(hosts) != 2 || !hosts[dummyHosts[0]] || !hosts[dummyHosts[1]] {
+		t.Errorf("Unexpected audited hosts: %v", hosts)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected audit log permissions: %v, %v", info.Mode(), err)
+	}
+	if err := sink.Err(); err != nil {
+		t.Errorf("Unexpected audit write error: %v", err)
+	}
+
+	// the records written after close fail, the commands don't
+	cluster.Cmds = cluster.Cmds[:1]
+	captureOutput(t, func() {
+		if _, err := cluster.Run("true"); err != nil {
+			t.Errorf("Unexpected error of the audited command: %v", err)
+		}
+	})
+	if err := sink.Err(); err == nil {
+		t.Error("Expected audit write error, but got nil")
+	}
+}
+
+func TestSyslogAuditSink(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Unix datagram sockets are not supported: %v", err)
+	}
+	defer conn.Close()
+
+	sink, err := execmd.NewSyslogAuditSink(addr, "deploy")
+	if err != nil {
+		t.Fatalf("Failed to connect to syslog: %v", err)
+	}
+	defer sink.Close()
+
+	cmd := execmd.NewCmd()
+	cmd.MuteCmd, cmd.MuteStdout = true, true
+	cmd.Audit = sink
+	cmd.Run("echo --password=secret")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("No syslog message: %v", err)
+	}
+	if !strings.HasPrefix(msg, "<85>") || !strings.Contains(msg, " deploy[") ||
+		!strings.Contains(msg, `"command":"echo --password=********"`) {
+		t.Errorf("Unexpected syslog message: %q", msg)
+	}
+
+	if _, err := execmd.NewSyslogAuditSink(filepath.Join(t.TempDir(), "none"), ""); err == nil {
+		t.Error("Expected error connecting to missing socket")
+	}
+}
@@ -0,0 +1,105 @@
+package execmd
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// BecomeMethod is a privilege escalation command.
+type BecomeMethod string
+
+// Privilege escalation commands supported by Become.
+const (
+	BecomeSudo BecomeMethod = "sudo"
+	BecomeSu   BecomeMethod = "su"
+	BecomeDoas BecomeMethod = "doas"
+)
+
+// redactedText replaces secrets in the command output
+const redactedText = "********"
+
+// errBecomeTTY is returned if the password of su or doas can't be typed into a terminal
+var errBecomeTTY = errors.New("become: su and doas read the password from a terminal only, run the command interactively, in a pseudo-terminal or use sudo")
+
+// Become runs commands as another user, root by default.
+// Without a password sudo and doas must not prompt for it, commands fail instead of waiting for input.
+// The password is fed to stdin of sudo (sudo -S), su and doas read it from a terminal: the remote
+// pseudo-terminal of ssh commands or the local one of Cmd.PTY, so local su and doas with a password
+// require .PTY or .Interactive. The password is never part of the command line and it's masked in the output.
+type Become struct {
+	Method   BecomeMethod
+	User     string
+	Password string
+}
+
+func (b *Become) method() BecomeMethod {
+	if b.Method == "" {
+		return BecomeSudo
+	}
+	return b.Method
+}
+
+func (b *Become) user() string {
+	if b.User == "" {
+		return "root"
+	}
+	return b.User
+}
+
+// needsTTY reports whether the password has to be typed into a terminal
+func (b *Become) needsTTY() bool {
+	return b.Password != "" && b.method() != BecomeSudo
+}
+
+// wrap returns the command running as the become user. If feedPassword is true,
+// the password is returned to be written to stdin, otherwise the method prompts for it if needed.
+func (b *Become) wrap(command string, feedPassword bool) (string, io.Reader) {
+	var password io.Reader
+	if feedPassword && b.Password != "" {
+		password = strings.NewReader(b.Password + "\n")
+	}
+
+	user := shellQuote(b.user())
+	switch b.method() {
+	case BecomeSu:
+		return "su " + user + " -c " + shellQuote(command), password
+	case BecomeDoas:
+		flags := ""
+		if b.Password == "" {
+			flags = "-n "
+		}
+		return "doas " + flags + "-u " + user + " sh -c " + shellQuote(command), password
+	default:
+		// -k ignores cached credentials, so the password is always read and never left for the command stdin
+		flags := "-n "
+		switch {
+		case password != nil:
+			flags = "-k -S -p '' "
+		case b.Password != "":
+			// prompts in the terminal of interactive commands
+			flags = ""
+		}
+		return "sudo " + flags + "-u " + user + " -- sh -c " + shellQuote(command), password
+	}
+}
+
+// secrets returns the strings to mask in the output
+func (b *Become) secrets() []string {
+	if b.Password == "" {
+		return nil
+	}
+	return []string{b.Password}
+}
+
+// withStdin prepends the password to stdin of the command
+func withStdin(password, stdin io.Reader) io.Reader {
+	switch {
+	case password == nil:
+		return stdin
+	case stdin == nil:
+		return password
+	default:
+		return io.MultiReader(password, stdin)
+	}
+}
@@ -0,0 +1,123 @@
+package execmd_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+// fakeSudo puts a sudo into PATH which checks the password from stdin and echoes it like a terminal would
+func fakeSudo(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+pw=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -S) read -r pw; echo "[sudo] password: $pw" >&2;;
+    -n) [ -n "$pw" ] || { echo "sudo: a password is required" >&2; exit 1; };;
+    -p|-u) shift;;
+    --) shift; break;;
+  esac
+  shift
+done
+[ "$pw" = "secret" ] || { echo "Sorry, try again." >&2; exit 1; }
+exec "$@"
+`
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+}
+
+func TestCmd_Become(t *testing.T) {
+	fakeSudo(t)
+
+	cmd := execmd.NewCmd()
+	cmd.Become = &execmd.Become{Password: "secret"}
+	res, err := cmd.Run("echo \"as $0\"")
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if res.Stdout.String() != "as sh\n" {
+		t.Errorf("Unexpected stdout output: %s", res.Stdout.String())
+	}
+	if strings.Contains(res.Stderr.String(), "secret") || !strings.Contains(res.Stderr.String(), "********") {
+		t.Errorf("Password is not masked in output: %s", res.Stderr.String())
+	}
+
+	cmd.Become = &execmd.Become{}
+	if _, err := cmd.Run("true"); err == nil {
+		t.Error("Expected error without password, but got nil")
+	}
+
+	cmd.Become = &execmd.Become{Method: execmd.BecomeSu, Password: "secret"}
+	if _, err := cmd.Run("true"); err == nil {
+		t.Error("Expected error on su password without terminal, but got nil")
+	}
+
+	if _, err := exec.LookPath("su"); err != nil || os.Getuid() != 0 {
+		t.Skip("su as root is required")
+	}
+	cmd.Become = &execmd.Become{Method: execmd.BecomeSu}
+	res, err = cmd.Run("id -un")
+	if err != nil || res.Stdout.String() != "root\n" {
+		t.Errorf("Unexpected su result: %q, %v", res.Stdout, err)
+	}
+}
+
+// fakeSSH returns an ssh executable which saves its arguments and stdin into the dir instead of connecting,
+// so the remote command is checked as it's sent to the host
+func fakeSSH(t *testing.T) (executable, dir string) {
+	dir = t.TempDir()
+	script := `#!/bin/sh
+printf '%s\n' "$@" > "` + dir + `/args"
+cat > "` + dir + `/stdin"
+`
+	executable = filepath.Join(dir, "ssh")
+	if err := os.WriteFile(executable, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return executable, dir
+}
+
+func TestSSHCmd_Become(t *testing.T) {
+	ssh, dir := fakeSSH(t)
+
+	srv := execmd.NewSSHCmd(dummyHost)
+	srv.SSHExecutable = ssh
+	srv.Become = &execmd.Become{Password: "secret"}
+	// the local become must not wrap ssh
+	srv.Cmd.Become = &execmd.Become{Password: "local"}
+
+	// the script follows the password on stdin
+	script := "echo \"it's $1\"\n"
+	if _, err := srv.RunScript(execmd.NewScript(script, "root")); err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if !strings.Contains(string(args), "sudo -k -S -p '' -u 'root' -- sh -c") {
+		t.Errorf("Remote command is not wrapped with sudo: %s", args)
+	}
+	if stdin, _ := os.ReadFile(filepath.Join(dir, "stdin")); string(stdin) != "secret\n"+script {
+		t.Errorf("Unexpected stdin: %q", stdin)
+	}
+
+	cluster := execmd.NewClusterSSHCmd(dummyHosts[:1])
+	cluster.Cmds[0].SSHCmd.SSHExecutable = ssh
+	cluster.Become = &execmd.Become{Method: execmd.BecomeDoas, User: "deploy"}
+	if _, err := cluster.Run("true"); err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if args, _ := os.ReadFile(filepath.Join(dir, "args")); !strings.Contains(string(args), "doas -n -u 'deploy' sh -c") {
+		t.Errorf("Remote command is not wrapped with doas: %s", args)
+	}
+}
@@ -1,17 +1,44 @@
 package execmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
 // ClusterSSHCmd is a wrapper on SSHCmd that allows executing commands on multiple hosts in parallel or sequentially.
+// It is safe to run several commands concurrently with .Run() methods or .StartProcess(),
+// .Errors holds errors of the last completed run.
 type ClusterSSHCmd struct {
 	Cmds   []ClusterCmd
 	Errors []error
 
 	Cwd         string
 	StopOnError bool
+	// Become runs the commands on all hosts as another user, see SSHCmd.Become
+	Become *Become
+	// Recorder records the output of all hosts in a single recording, see Recorder
+	Recorder *Recorder
+	// Audit writes audit records of the commands on all hosts, see AuditSink
+	Audit AuditSink
+	// KillRemote kills the remote commands on all hosts on timeout or cancel, see SSHCmd.KillRemote
+	KillRemote bool
+	// Template renders the command for every host with text/template, see ClusterCmdData
+	Template bool
+	// Timeout is a cluster-wide deadline for the whole run, unlike the per-host timeout of .Run() methods
+	Timeout time.Duration
+	// MaxParallel limits the hosts running at once in parallel runs, all the hosts start at once if zero.
+	// A host starts when another one exits, so with the limit .Start() returns when the last host starts.
+	MaxParallel int
+
+	mu   sync.Mutex
+	proc *ClusterProcess
+	// hostsErr fails the runs if the hosts failed to expand
+	hostsErr error
 }
 
 // ClusterCmd wraps SSHCmd and preserves the host name, and saves errors from .Start() for the .Wait() method.
@@ -19,6 +46,18 @@ type ClusterCmd struct {
 	SSHCmd SSHCmd
 
 	Host string
+	Vars map[string]string
+}
+
+// ClusterCmdData is passed to the command template of each host, e.g.
+// `echo {{.Host}} is {{.Index}} of {{.Count}} with shard {{.Vars.shard_id}}`.
+type ClusterCmdData struct {
+	Host  string
+	User  string
+	Port  string
+	Index int
+	Count int
+	Vars  map[string]string
 }
 
 // ClusterRes contains the results of the command execution.
@@ -26,11 +65,201 @@ type ClusterRes struct {
 	Host string
 	Err  error
 	Res  CmdRes
+	// SSHErr is set if the host failed on ssh transport level, e.g. it is unreachable
+	SSHErr *SSHError
+	// Skipped is set if the host was not started because the cluster run was interrupted
+	Skipped bool
+}
+
+// complete saves the result of the completed host process.
+func (r *ClusterRes) complete(proc *Process, err error) {
+	r.Res = proc.Res
+	r.Err = err
+	r.SSHErr = AsSSHError(err)
+}
+
+// Errors of hosts killed or not started because the whole cluster run was interrupted,
+// the original host error follows in the error message.
+var (
+	ErrClusterTimeout = errors.New("cluster timeout exceeded")
+	ErrStopped        = errors.New("stopped on error of another host")
+	ErrCanceled       = errors.New("cluster run canceled")
+)
+
+// ClusterProcess is a command started on cluster hosts. It holds the execution state separately
+// from ClusterSSHCmd, so each started command has its own processes and results.
+type ClusterProcess struct {
+	Results []ClusterRes
+	// Procs holds the process of every started host, it is nil for hosts which were not started
+	Procs []*Process
+
+	stopOnError bool
+	parent      context.Context
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	mu        sync.Mutex
+	stopCause error
+	waitOnce  sync.Once
+	waitErr   error
+
+	// hosts waits for the started hosts, each host saves its result to done when it exits
+	hosts  sync.WaitGroup
+	doneMu sync.Mutex
+	done   []*ClusterRes
+	// onResult is called with every host result in completion order, see RunStream
+	onResult func(res ClusterRes)
+}
+
+// newClusterProcess initializes ClusterProcess for n hosts with an optional cluster-wide timeout,
+// the processes are canceled when ctx is done.
+func newClusterProcess(ctx context.Context, n int, stopOnError bool, timeout time.Duration) *ClusterProcess {
+	p := &ClusterProcess{
+		Results:     make([]ClusterRes, n),
+		Procs:       make([]*Process, n),
+		done:        make([]*ClusterRes, n),
+		stopOnError: stopOnError,
+		parent:      ctx,
+	}
+
+	if timeout > 0 {
+		p.ctx, p.cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		p.ctx, p.cancel = context.WithCancel(ctx)
+	}
+
+	return p
+}
+
+// Cancel kills the processes still running on the hosts, their errors wrap ErrCanceled.
+func (p *ClusterProcess) Cancel() {
+	p.stop(ErrCanceled)
+}
+
+// Wait waits for all the started hosts concurrently and saves errors to .Results.
+// It returns the first caught error, if .StopOnError was true on start
+// the hosts still running are killed on the first error.
+// It is safe to call Wait several times, all calls return the same error.
+func (p *ClusterProcess) Wait() error {
+	p.waitOnce.Do(func() {
+		p.hosts.Wait()
+
+		p.doneMu.Lock()
+		defer p.doneMu.Unlock()
+		for i := range p.Results {
+			if p.done[i] != nil {
+				p.Results[i] = *p.done[i]
+			}
+		}
+
+		// release the resources associated with the context
+		p.cancel()
+	})
+
+	return p.waitErr
+}
+
+// waitHost waits for the started host in the background and reports its result when it exits,
+// the slot of the host is freed then. On error with .StopOnError the remaining hosts are stopped.
+// The result is passed by value, so it's not shared with the callers of .Start() until .Wait().
+func (p *ClusterProcess) waitHost(i int, res ClusterRes, proc *Process, slots chan struct{}) {
+	defer p.hosts.Done()
+
+	err := p.hostErr(proc, proc.Wait())
+	if err != nil && p.stopOnError {
+		p.stop(ErrStopped)
+	}
+
+	res.complete(proc, err)
+	p.report(i, res)
+
+	if slots != nil {
+		<-slots
+	}
+}
+
+// report saves the result of the completed host, or of the host which didn't start,
+// and passes it to .onResult
+func (p *ClusterProcess) report(i int, res ClusterRes) {
+	p.doneMu.Lock()
+	defer p.doneMu.Unlock()
+
+	p.done[i] = &res
+	if res.Err != nil && p.waitErr == nil {
+		p.waitErr = fmt.Errorf("error on host %s: %w", res.Host, res.Err)
+	}
+	if p.onResult != nil {
+		p.onResult(res)
+	}
+}
+
+// stop kills the processes still running and saves the reason.
+func (p *ClusterProcess) stop(cause error) {
+	p.mu.Lock()
+	if p.stopCause == nil && p.ctx.Err() == nil {
+		p.stopCause = cause
+	}
+	p.mu.Unlock()
+
+	p.cancel()
+}
+
+// cause returns the reason why the cluster run was interrupted, or nil.
+func (p *ClusterProcess) cause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopCause != nil {
+		return p.stopCause
+	}
+	if p.ctx.Err() == context.DeadlineExceeded {
+		return ErrClusterTimeout
+	}
+	if p.parent.Err() != nil {
+		return ErrCanceled
+	}
+	return nil
+}
+
+// hostErr marks errors of hosts killed because the cluster run was interrupted,
+// errors of hosts which exited on their own are kept as is.
+func (p *ClusterProcess) hostErr(proc *Process, err error) error {
+	if err == nil || !proc.interrupted {
+		return err
+	}
+	if cause := p.cause(); cause != nil {
+		return &interruptedError{cause: cause, err: err}
+	}
+	return err
+}
+
+// interruptedError is the error of a host killed because the cluster run was interrupted,
+// it matches both the cause (ErrCanceled, ErrClusterTimeout) and the host error, e.g. SSHError.
+type interruptedError struct {
+	cause error
+	err   error
+}
+
+func (e *interruptedError) Error() string {
+	return e.cause.Error() + ": " + e.err.Error()
+}
+
+func (e *interruptedError) Is(target error) bool {
+	return errors.Is(e.cause, target)
+}
+
+func (e *interruptedError) Unwrap() error {
+	return e.err
 }
 
 // NewClusterSSHCmd initializes ClusterSSHCmd with defaults.
+// Every host could be a nodeset pattern (e.g. root@web[01-40]!root@web07), see ExpandHosts.
+// If a pattern is invalid the cluster has no hosts and its runs return the error of the pattern,
+// validate user input with ExpandHosts beforehand.
 func NewClusterSSHCmd(hosts []string) *ClusterSSHCmd {
-	c := ClusterSSHCmd{}
+	hosts, err := expandHostList(hosts)
+
+	c := ClusterSSHCmd{hostsErr: err}
 	c.StopOnError = false
 	c.Cmds = make([]ClusterCmd, len(hosts))
 	c.Errors = make([]error, len(hosts))
@@ -41,52 +270,214 @@ func NewClusterSSHCmd(hosts []string) *ClusterSSHCmd {
 	return &c
 }
 
-// start iterates through the hosts and runs .Start() or .Run() method (depends on `parallel` flag).
-func (c *ClusterSSHCmd) start(command string, parallel bool, timeout ...time.Duration) ([]ClusterRes, error) {
-	results := make([]ClusterRes, len(c.Cmds))
+// start iterates through the hosts and starts the process, waiting for it if `parallel` flag is false,
+// with the command of each host, commands are in the same order as .Cmds.
+// The returned ClusterProcess is nil only if commands can't be rendered or the hosts failed to expand.
+func (c *ClusterSSHCmd) start(commands []string, parallel bool, timeout ...time.Duration) (*ClusterProcess, error) {
+	return c.startContext(context.Background(), commands, parallel, timeout...)
+}
+
+// startContext starts the commands like .start(), the processes are canceled when ctx is done.
+func (c *ClusterSSHCmd) startContext(ctx context.Context, commands []string, parallel bool, timeout ...time.Duration) (*ClusterProcess, error) {
+	commands, err := c.renderCommands(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.startEach(ctx, parallel, func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error) {
+		return ssh.start(ctx, commands[i], timeout...)
+	})
+}
+
+// hostStarter starts a process for the host at index i of .Cmds, the process is killed when ctx is done.
+type hostStarter func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error)
+
+// startEach iterates through the hosts and starts the process of each host with startHost,
+// waiting for it if `parallel` flag is false. The processes are canceled when ctx is done.
+// On error with .StopOnError the hosts started before are killed and waited for.
+func (c *ClusterSSHCmd) startEach(ctx context.Context, parallel bool, startHost hostStarter) (*ClusterProcess, error) {
+	return c.startHosts(c.newProcess(ctx), parallel, startHost)
+}
+
+// newProcess returns the process of a run on the hosts, canceled when ctx is done
+func (c *ClusterSSHCmd) newProcess(ctx context.Context) *ClusterProcess {
+	return newClusterProcess(ctx, len(c.Cmds), c.StopOnError, c.Timeout)
+}
+
+// startHosts starts the hosts of the process like .startEach(), the parallel hosts are waited for
+// in the background and report their results as they exit.
+func (c *ClusterSSHCmd) startHosts(cp *ClusterProcess, parallel bool, startHost hostStarter) (*ClusterProcess, error) {
+	if c.hostsErr != nil {
+		cp.cancel()
+		return nil, c.hostsErr
+	}
+
+	if !parallel {
+		defer cp.cancel()
+	}
+
+	// slots limits the hosts running at once, a host frees its slot on exit
+	var slots chan struct{}
+	if parallel && c.MaxParallel > 0 && c.MaxParallel < len(c.Cmds) {
+		slots = make(chan struct{}, c.MaxParallel)
+	}
+
 	for i, cmd := range c.Cmds {
+		// every host gets its own copy, the started process keeps referring to it
+		cmd := cmd
+
 		// Set cluster common variables
 		if c.Cwd != "" {
 			cmd.SSHCmd.Cwd = c.Cwd
 		}
+		if c.Become != nil {
+			cmd.SSHCmd.Become = c.Become
+		}
+		if c.Recorder != nil {
+			cmd.SSHCmd.Recorder = c.Recorder
+		}
+		if c.Audit != nil {
+			cmd.SSHCmd.Audit = c.Audit
+		}
+		if c.KillRemote {
+			cmd.SSHCmd.KillRemote = true
+		}
+
+		cp.Results[i].Host = cmd.Host
 
-		results[i].Host = cmd.Host
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-cp.ctx.Done():
+			}
+		}
 
-		// No need to implement full interfaces here, we use only: .Start() and .Run() methods
-		exec := cmd.SSHCmd.Start
-		if !parallel {
-			exec = cmd.SSHCmd.Run
+		// don't start the remaining hosts after the cluster timeout
+		if cause := cp.cause(); cause != nil {
+			cp.Results[i].Err = cause
+			cp.Results[i].Skipped = true
+			cp.report(i, cp.Results[i])
+			continue
+		}
+
+		proc, err := startHost(cp.ctx, i, &cmd.SSHCmd)
+		if proc != nil {
+			cp.Results[i].Res = proc.Res
+			if err == nil {
+				cp.Procs[i] = proc
+				if !parallel {
+					err = cp.hostErr(proc, proc.Wait())
+					cp.Results[i].complete(proc, err)
+				}
+			}
 		}
+		cp.Results[i].Err = err
 
-		results[i].Res, results[i].Err = exec(command, timeout...)
+		if parallel && cp.Procs[i] != nil {
+			cp.hosts.Add(1)
+			go cp.waitHost(i, cp.Results[i], proc, slots)
+		} else {
+			cp.report(i, cp.Results[i])
+			if slots != nil {
+				<-slots
+			}
+		}
 
-		if c.StopOnError && results[i].Err != nil {
-			return results[:i+1], fmt.Errorf("error on host %s: %w", cmd.Host, results[i].Err)
+		if c.StopOnError && err != nil {
+			cp.Results, cp.Procs = cp.Results[:i+1], cp.Procs[:i+1]
+			if parallel {
+				cp.stop(ErrStopped)
+				cp.Wait()
+			}
+			return cp, fmt.Errorf("error on host %s: %w", cmd.Host, err)
 		}
 	}
 
-	return results, nil
+	if cause := cp.cause(); !parallel && cause != nil {
+		return cp, cause
+	}
+
+	return cp, nil
 }
 
-// Wait calls SSHCmd.Wait for each Cmd in the list of ClusterCmds.
-// It returns the first caught .Wait() error ans stops if .StopOnError is true.
-// To see underlying SSHCmd command errors, access the .Cmds attribute.
-func (c *ClusterSSHCmd) Wait() error {
-	var firstErr error
-	for i, cmd := range c.Cmds {
-		if err := cmd.SSHCmd.Wait(); err != nil {
-			c.Errors[i] = err
+// setErrors saves errors of the completed process to .Errors.
+func (c *ClusterSSHCmd) setErrors(cp *ClusterProcess) {
+	errs := make([]error, len(c.Cmds))
+	for i := range cp.Results {
+		errs[i] = cp.Results[i].Err
+	}
 
-			if firstErr == nil {
-				firstErr = fmt.Errorf("error on host %s: %w", cmd.Host, err)
-				if c.StopOnError {
-					return firstErr
-				}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Errors = errs
+}
+
+// sameCommand returns the command repeated for every host.
+func (c *ClusterSSHCmd) sameCommand(command string) []string {
+	commands := make([]string, len(c.Cmds))
+	for i := range commands {
+		commands[i] = command
+	}
+	return commands
+}
+
+// renderCommands renders the command of every host as a template if .Template is true.
+// All the templates are rendered before any host starts, so a template error doesn't leave the cluster half done.
+func (c *ClusterSSHCmd) renderCommands(commands []string) ([]string, error) {
+	if !c.Template {
+		return commands, nil
+	}
+
+	rendered := make([]string, len(commands))
+	templates := map[string]*template.Template{}
+	for i, cmd := range c.Cmds {
+		tmpl, ok := templates[commands[i]]
+		if !ok {
+			var err error
+			tmpl, err = template.New("command").Option("missingkey=error").Parse(commands[i])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse command template for host %s: %w", cmd.Host, err)
 			}
+			templates[commands[i]] = tmpl
 		}
+
+		data := ClusterCmdData{
+			Host:  cmd.Host,
+			User:  cmd.SSHCmd.User,
+			Port:  cmd.SSHCmd.Port,
+			Index: i,
+			Count: len(c.Cmds),
+			Vars:  cmd.Vars,
+		}
+		if data.Vars == nil {
+			data.Vars = map[string]string{}
+		}
+
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render command for host %s: %w", cmd.Host, err)
+		}
+		rendered[i] = buf.String()
+	}
+
+	return rendered, nil
+}
+
+// Wait calls SSHCmd.Wait for each host of the command started with .Start().
+// It returns the first caught .Wait() error ans stops if .StopOnError is true.
+// To see underlying SSHCmd command errors, access the .Errors attribute.
+func (c *ClusterSSHCmd) Wait() error {
+	c.mu.Lock()
+	cp := c.proc
+	c.mu.Unlock()
+
+	if cp == nil {
+		return errors.New("execmd: cluster command not started")
 	}
 
-	return firstErr
+	err := cp.Wait()
+	c.setErrors(cp)
+	return err
 }
 
 // Run executes a command in parallel on all hosts and waits for the results.
@@ -94,30 +485,143 @@ func (c *ClusterSSHCmd) Wait() error {
 // It returns results and the first caught error.
 // To see underlying SSHCmd command errors, access the .Cmds attribute.
 func (c *ClusterSSHCmd) Run(command string, timeout ...time.Duration) (results []ClusterRes, err error) {
-	if results, err = c.Start(command, timeout...); err != nil {
-		return
+	return c.run(c.sameCommand(command), timeout...)
+}
+
+// RunContext executes the command in parallel like .Run(), the hosts are killed when ctx is done
+// and their errors wrap ErrCanceled.
+func (c *ClusterSSHCmd) RunContext(ctx context.Context, command string, timeout ...time.Duration) ([]ClusterRes, error) {
+	cp, err := c.startContext(ctx, c.sameCommand(command), true, timeout...)
+	return c.waitResults(cp, err)
+}
+
+// RunFunc executes in parallel a different command on every host, as returned by commandFn for the host name,
+// and waits for the results. It returns results and the first caught error.
+func (c *ClusterSSHCmd) RunFunc(commandFn func(host string) string, timeout ...time.Duration) (results []ClusterRes, err error) {
+	commands := make([]string, len(c.Cmds))
+	for i, cmd := range c.Cmds {
+		commands[i] = commandFn(cmd.Host)
+	}
+
+	return c.run(commands, timeout...)
+}
+
+// RunMap executes in parallel a different command on every host, as mapped by host names,
+// and waits for the results. Nothing is started if any host has no command.
+// It returns results and the first caught error.
+func (c *ClusterSSHCmd) RunMap(commands map[string]string, timeout ...time.Duration) (results []ClusterRes, err error) {
+	for _, cmd := range c.Cmds {
+		if _, ok := commands[cmd.Host]; !ok {
+			return nil, fmt.Errorf("no command for host %s", cmd.Host)
+		}
+	}
+
+	return c.RunFunc(func(host string) string { return commands[host] }, timeout...)
+}
+
+// run starts the commands in parallel and waits for the results.
+func (c *ClusterSSHCmd) run(commands []string, timeout ...time.Duration) ([]ClusterRes, error) {
+	cp, err := c.start(commands, true, timeout...)
+	return c.waitResults(cp, err)
+}
+
+// runEach starts the process of every host in parallel with startHost and waits for the results.
+func (c *ClusterSSHCmd) runEach(startHost hostStarter) ([]ClusterRes, error) {
+	return c.runEachContext(context.Background(), startHost)
+}
+
+// runEachContext starts the processes like .runEach(), the processes are killed when ctx is done.
+func (c *ClusterSSHCmd) runEachContext(ctx context.Context, startHost hostStarter) ([]ClusterRes, error) {
+	cp, err := c.startEach(ctx, true, startHost)
+	return c.waitResults(cp, err)
+}
+
+// waitResults waits for the cluster process if it started without error and saves .Errors.
+func (c *ClusterSSHCmd) waitResults(cp *ClusterProcess, err error) ([]ClusterRes, error) {
+	if cp == nil {
+		return nil, err
+	}
+
+	if err == nil {
+		err = cp.Wait()
+	}
+
+	c.setErrors(cp)
+	return cp.Results, err
+}
+
+// RunStream executes a command in parallel on all hosts and sends every host result
+// to the returned channel as soon as that host completes, in completion order.
+// The channel is closed when all hosts are done, the hosts beyond .MaxParallel are started in the background.
+// To see underlying SSHCmd command errors, check the .Err field of each result.
+func (c *ClusterSSHCmd) RunStream(command string, timeout ...time.Duration) (<-chan ClusterRes, error) {
+	if c.hostsErr != nil {
+		return nil, c.hostsErr
+	}
+	commands, err := c.renderCommands(c.sameCommand(command))
+	if err != nil {
+		return nil, err
 	}
 
-	err = c.Wait()
+	// buffered, so the hosts never block on a slow consumer
+	resCh := make(chan ClusterRes, len(c.Cmds))
 
-	// populate .Err
-	for i, err := range c.Errors {
-		results[i].Err = err
+	// the results are sent as the hosts exit, while the hosts beyond .MaxParallel are still starting
+	cp := c.newProcess(context.Background())
+	cp.onResult = func(res ClusterRes) {
+		resCh <- res
 	}
 
-	return
+	go func() {
+		c.startHosts(cp, true, func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error) {
+			return ssh.start(ctx, commands[i], timeout...)
+		})
+		cp.Wait()
+		c.setErrors(cp)
+		close(resCh)
+	}()
+
+	return resCh, nil
 }
 
 // RunOneByOne executes a command in series: run at the first host, then run at the second host, and so on.
 // It returns results and the first caught error.
 // To see underlying SSHCmd command errors, access the .Cmds attribute.
 func (c *ClusterSSHCmd) RunOneByOne(command string, timeout ...time.Duration) (results []ClusterRes, err error) {
-	return c.start(command, false, timeout...)
+	return c.RunOneByOneContext(context.Background(), command, timeout...)
+}
+
+// RunOneByOneContext executes the command in series like .RunOneByOne(), the running host is killed
+// and the remaining ones are not started when ctx is done.
+func (c *ClusterSSHCmd) RunOneByOneContext(ctx context.Context, command string, timeout ...time.Duration) (results []ClusterRes, err error) {
+	cp, err := c.startContext(ctx, c.sameCommand(command), false, timeout...)
+	if cp == nil {
+		return nil, err
+	}
+
+	c.setErrors(cp)
+	return cp.Results, err
 }
 
 // Start executes a command in parallel on all hosts without waiting for the results.
 // The command starts simultaneously on each host.
 // It returns results and the first caught error.
 func (c *ClusterSSHCmd) Start(command string, timeout ...time.Duration) (results []ClusterRes, err error) {
-	return c.start(command, true, timeout...)
+	cp, err := c.start(c.sameCommand(command), true, timeout...)
+
+	c.mu.Lock()
+	c.proc = cp
+	c.mu.Unlock()
+
+	if cp == nil {
+		return nil, err
+	}
+	return cp.Results, err
+}
+
+// StartProcess executes a command in parallel on all hosts like .Start(), but returns its own ClusterProcess to wait for,
+// so the same cluster could run several commands concurrently.
+// On error the ClusterProcess holds the hosts started before the error, if any.
+func (c *ClusterSSHCmd) StartProcess(command string, timeout ...time.Duration) (*ClusterProcess, error) {
+	return c.start(c.sameCommand(command), true, timeout...)
 }
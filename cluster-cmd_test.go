@@ -3,7 +3,11 @@
 package execmd_test
 
 import (
+	"context"
+	"errors"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -207,3 +211,321 @@ func TestNewClusterSSHCmd_MultiRun(t *testing.T) {
 		}
 	}
 }
+
+func TestClusterSSHCmd_RunStream(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	resCh, err := cluster.RunStream("echo streamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := map[string]bool{}
+	for res := range resCh {
+		if res.Err != nil {
+			t.Errorf("Error on host %s: %v", res.Host, res.Err)
+		}
+		if res.Res.Stdout.String() != "streamed\n" {
+			t.Errorf("Unexpected stdout on host %s: %s", res.Host, res.Res.Stdout)
+		}
+		hosts[res.Host] = true
+	}
+
+	if len(hosts) != len(dummyHosts) {
+		t.Errorf("RunStream: number of results not equals to hosts number")
+	}
+}
+
+func TestClusterSSHCmd_RunStreamMaxParallel(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd([]string{dummyHosts[0], dummyHosts[1], dummyHosts[0] + ":22"})
+	cluster.MaxParallel = 1
+	cluster.Template = true
+
+	started := time.Now()
+	// the first host is sent before the slow second one exits and the last one starts
+	resCh, err := cluster.RunStream("{{if eq .Index 1}}sleep 1; {{end}}echo {{.Index}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for res := range resCh {
+		if len(order) == 0 && time.Since(started) > 700*time.Millisecond {
+			t.Errorf("First result is sent after %s", time.Since(started))
+		}
+		order = append(order, strings.TrimSpace(res.Res.Stdout.String()))
+	}
+	if strings.Join(order, ",") != "0,1,2" {
+		t.Errorf("Unexpected order of results: %v", order)
+	}
+}
+
+func TestNewClusterSSHCmd_HostPatterns(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd([]string{"deploy@web[01-04]:2222!deploy@web02:2222", "db1"})
+
+	expected := []string{"deploy@web01:2222", "deploy@web03:2222", "deploy@web04:2222", "db1"}
+	if len(cluster.Cmds) != len(expected) {
+		t.Fatalf("Expected %d hosts, got %d", len(expected), len(cluster.Cmds))
+	}
+
+	for i, cmd := range cluster.Cmds {
+		if cmd.Host != expected[i] {
+			t.Errorf("Expected host %s, got %s", expected[i], cmd.Host)
+		}
+	}
+	if cluster.Cmds[0].SSHCmd.User != "deploy" || cluster.Cmds[0].SSHCmd.Port != "2222" || cluster.Cmds[0].SSHCmd.Host != "web01" {
+		t.Errorf("Unexpected ssh settings: %+v", cluster.Cmds[0].SSHCmd)
+	}
+	if cluster.Cmds[3].SSHCmd.User != "" || cluster.Cmds[3].SSHCmd.Port != "" {
+		t.Errorf("Unexpected ssh settings: %+v", cluster.Cmds[3].SSHCmd)
+	}
+
+	cluster = execmd.NewClusterSSHCmd([]string{"localhost", "web[01-"})
+	if len(cluster.Cmds) != 0 {
+		t.Errorf("Invalid pattern is kept as a host: %+v", cluster.Cmds)
+	}
+	if _, err := cluster.Run("true"); err == nil || !strings.Contains(err.Error(), "web[01-") {
+		t.Errorf("Expected the pattern error, got: %v", err)
+	}
+}
+
+func TestClusterSSHCmd_Template(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+	cluster.Template = true
+	for i := range cluster.Cmds {
+		cluster.Cmds[i].Vars = map[string]string{"shard_id": strings.Repeat("s", i+1)}
+	}
+
+	res, err := cluster.Run("echo {{.Host}} {{.Index}}/{{.Count}} {{.Vars.shard_id}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Res.Stdout.String() != dummyHosts[0]+" 0/2 s\n" {
+		t.Errorf("Unexpected stdout on host %s: %s", res[0].Host, res[0].Res.Stdout)
+	}
+	if res[1].Res.Stdout.String() != dummyHosts[1]+" 1/2 ss\n" {
+		t.Errorf("Unexpected stdout on host %s: %s", res[1].Host, res[1].Res.Stdout)
+	}
+
+	if _, err := cluster.Run("echo {{.Vars.missing}}"); err == nil {
+		t.Error("Expected error for missing variable, but got nil")
+	}
+
+	cluster.Template = false
+	res, err = cluster.Run("echo '{{.Host}}'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Res.Stdout.String() != "{{.Host}}\n" {
+		t.Errorf("Unexpected stdout on host %s: %s", res[0].Host, res[0].Res.Stdout)
+	}
+}
+
+func TestClusterSSHCmd_RunMap(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	res, err := cluster.RunMap(map[string]string{
+		dummyHosts[0]: "echo primary",
+		dummyHosts[1]: "echo replica",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Res.Stdout.String() != "primary\n" || res[1].Res.Stdout.String() != "replica\n" {
+		t.Errorf("Unexpected outputs: %s, %s", res[0].Res.Stdout, res[1].Res.Stdout)
+	}
+
+	_, err = cluster.RunMap(map[string]string{dummyHosts[0]: "echo primary"})
+	if err == nil {
+		t.Error("Expected error for host without command, but got nil")
+	}
+}
+
+func TestClusterSSHCmd_RunFunc(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	res, err := cluster.RunFunc(func(host string) string {
+		return "echo " + host
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Res.Stdout.String() != r.Host+"\n" {
+			t.Errorf("Unexpected stdout on host %s: %s", r.Host, r.Res.Stdout)
+		}
+	}
+}
+
+func TestClusterSSHCmd_ConcurrentRun(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			expected := strconv.Itoa(i) + "\n"
+			res, err := cluster.Run("echo " + strconv.Itoa(i))
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			for _, r := range res {
+				if r.Res.Stdout.String() != expected {
+					t.Errorf("Unexpected stdout on host %s: %s, expected %s", r.Host, r.Res.Stdout, expected)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestClusterSSHCmd_StartProcess(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	first, err := cluster.StartProcess("sleep 0.2; echo first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cluster.StartProcess("give-me-error")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Wait(); err == nil {
+		t.Error("Expected error, but got nil")
+	}
+	if err := first.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range dummyHosts {
+		if first.Results[i].Res.Stdout.String() != "first\n" || first.Results[i].Err != nil {
+			t.Errorf("Unexpected first result on host %s: %s, %v", first.Results[i].Host, first.Results[i].Res.Stdout, first.Results[i].Err)
+		}
+		if second.Results[i].Err == nil {
+			t.Errorf("Expected an error on host %s, but got nil", second.Results[i].Host)
+		}
+	}
+}
+
+func TestClusterSSHCmd_ClusterTimeout(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+	cluster.Timeout = time.Second
+
+	started := time.Now()
+	res, err := cluster.Run("sleep 3; echo OK", 5*time.Second)
+	if !errors.Is(err, execmd.ErrClusterTimeout) {
+		t.Errorf("Expected cluster timeout error, got: %v", err)
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("Cluster timeout is not honoured, run took %s", time.Since(started))
+	}
+	for _, r := range res {
+		if !errors.Is(r.Err, execmd.ErrClusterTimeout) {
+			t.Errorf("Expected cluster timeout error on host %s, got: %v", r.Host, r.Err)
+		}
+	}
+
+	cluster.Timeout = 1500 * time.Millisecond
+	res, err = cluster.RunOneByOne("sleep 1; echo OK")
+	if !errors.Is(err, execmd.ErrClusterTimeout) {
+		t.Errorf("Expected cluster timeout error, got: %v", err)
+	}
+	if res[0].Err != nil || !errors.Is(res[1].Err, execmd.ErrClusterTimeout) {
+		t.Errorf("Unexpected errors: %v, %v", res[0].Err, res[1].Err)
+	}
+}
+
+func TestClusterSSHCmd_StopOnErrorCancelsHosts(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+	cluster.StopOnError = true
+
+	started := time.Now()
+	res, err := cluster.RunMap(map[string]string{
+		dummyHosts[0]: "echo slow; sleep 3",
+		dummyHosts[1]: "sleep 0.5; give-me-error",
+	})
+	if err == nil {
+		t.Error("Expected error, but got nil")
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("Remaining hosts are not canceled, run took %s", time.Since(started))
+	}
+	if !errors.Is(res[0].Err, execmd.ErrStopped) {
+		t.Errorf("Expected stopped error on host %s, got: %v", res[0].Host, res[0].Err)
+	}
+	if res[0].Res.Stdout.String() != "slow\n" {
+		t.Errorf("Unexpected stdout on host %s: %s", res[0].Host, res[0].Res.Stdout)
+	}
+	if res[1].Err == nil || errors.Is(res[1].Err, execmd.ErrStopped) {
+		t.Errorf("Unexpected error on host %s: %v", res[1].Host, res[1].Err)
+	}
+}
+
+func TestClusterSSHCmd_MaxParallel(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd([]string{"host-[01-04]"})
+	cluster.MaxParallel = 2
+
+	started := time.Now()
+	res, err := cluster.Run("sleep 0.4")
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 800*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected two batches of hosts, run took %s", elapsed)
+	}
+	if len(res) != 4 {
+		t.Errorf("Unexpected results: %d", len(res))
+	}
+
+	cluster.MaxParallel = 1
+	cluster.StopOnError = true
+	res, err = cluster.RunMap(map[string]string{
+		"host-01": "true",
+		"host-02": "give-me-error",
+		"host-03": "true",
+		"host-04": "true",
+	})
+	if err == nil {
+		t.Error("Expected error, but got nil")
+	}
+	if res[0].Err != nil {
+		t.Errorf("Unexpected error on host %s: %v", res[0].Host, res[0].Err)
+	}
+	if res[1].Err == nil || errors.Is(res[1].Err, execmd.ErrStopped) {
+		t.Errorf("Unexpected error on host %s: %v", res[1].Host, res[1].Err)
+	}
+	for _, r := range res[2:] {
+		if !errors.Is(r.Err, execmd.ErrStopped) || r.Res.Stdout != nil {
+			t.Errorf("Expected host %s not started, got: %v", r.Host, r.Err)
+		}
+	}
+}
+
+func TestClusterSSHCmd_RunContext(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	for _, run := range []func(context.Context, string, ...time.Duration) ([]execmd.ClusterRes, error){
+		cluster.RunContext, cluster.RunOneByOneContext,
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(300*time.Millisecond, cancel)
+
+		start := time.Now()
+		res, err := run(ctx, "sleep 5")
+		if time.Since(start) > 3*time.Second {
+			t.Errorf("Run was not canceled in time: %s", time.Since(start))
+		}
+		if !errors.Is(err, execmd.ErrCanceled) {
+			t.Errorf("Expected ErrCanceled, got: %v", err)
+		}
+		for _, r := range res {
+			if !errors.Is(r.Err, execmd.ErrCanceled) {
+				t.Errorf("Expected ErrCanceled on host %s, got: %v", r.Host, r.Err)
+			}
+		}
+	}
+}
@@ -6,10 +6,15 @@ package execmd
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -19,6 +24,10 @@ var shellPathList = []string{os.Getenv("SHELL"), "bash", "sh"}
 // Cmd is a wrapper struct around exec.Cmd that provides additional
 // functionality such as recording and muting stdout and stderr, and
 // customizing output prefixes.
+// Cmd holds configuration only, so it is safe to run several commands concurrently with
+// .Run() or .StartProcess(); .Cmd and .CancelFunc refer to the last command started with .Start() or .Run().
+// On Unix non-interactive commands run in their own process group, so they could be killed with their children,
+// and Ctrl-C in the terminal doesn't reach them: cancel them with .RunContext() and CancelOnInterrupt.
 type Cmd struct {
 	ShellPath    string
 	Interactive  bool
@@ -32,14 +41,105 @@ type Cmd struct {
 	PrefixStderr string
 	PrefixCmd    string
 	CancelFunc   context.CancelFunc
+	// Become runs commands as another user with sudo, su or doas
+	Become *Become
+	// PTY runs commands in a pseudo-terminal, so they behave as in a console: stdout and stderr
+	// are merged into stdout with \r\n line endings, and interactive commands read the console in raw mode
+	PTY bool
+	// JSONLines prints every output line and the command as a JSON object (OutputEvent) instead of the prefixed text,
+	// e.g. for log pipelines
+	JSONLines bool
+	// Recorder records the displayed command and output with the timing, muted output is not recorded
+	Recorder *Recorder
+	// Audit writes an audit record of every command when it completes, see AuditSink
+	Audit AuditSink
 
 	Cmd *exec.Cmd
+
+	mu   sync.Mutex
+	proc *Process
 }
 
 // CmdRes represents the result of a command, including the stdout and stderr buffers.
+// ExitCode, Duration and TimedOut are set when the command completes, so they are zero in results of .Start().
 type CmdRes struct {
 	Stdout *bytes.Buffer
 	Stderr *bytes.Buffer
+
+	ExitCode int
+	Duration time.Duration
+	// TimedOut is set if the command was killed on timeout
+	TimedOut bool
+}
+
+// Process is a started command. It holds the execution state separately from Cmd,
+// so each started command has its own exec.Cmd, output buffers and timeout context.
+type Process struct {
+	Cmd *exec.Cmd
+	Res CmdRes
+
+	cancel  context.CancelFunc
+	onKill  func()
+	onExit  func()
+	mapErr  func(err error, stderrTail []string) error
+	started time.Time
+	exited  chan struct{}
+	killed  chan struct{}
+	stdout  *prefixedStream
+	stderr  *prefixedStream
+	// release is called when the process exits, before the output buffers are flushed
+	release func()
+	// interrupted is set when the process is killed because its context is done, timedOut if it's done on timeout
+	interrupted bool
+	timedOut    bool
+	// audit is the record written to auditSink when the process exits
+	audit     *AuditRecord
+	auditSink AuditSink
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// Cancel kills the process if it's still running, Wait returns the kill error.
+func (p *Process) Cancel() {
+	p.cancel()
+}
+
+// Wait waits for the process to exit, flushes the output buffers and releases the timeout context.
+// It is safe to call Wait several times and from several goroutines, all calls return the same error.
+func (p *Process) Wait() error {
+	p.waitOnce.Do(func() {
+		p.waitErr = p.Cmd.Wait()
+		close(p.exited)
+		<-p.killed
+		if p.onExit != nil {
+			p.onExit()
+		}
+
+		if p.release != nil {
+			p.release()
+		}
+
+		// call the cancel function to always release the resources associated with the context
+		p.cancel()
+
+		p.stderr.Close()
+		p.stdout.Close()
+
+		if p.mapErr != nil {
+			p.waitErr = p.mapErr(p.waitErr, p.stderr.Tail())
+		}
+
+		p.Res.ExitCode = exitCode(p.waitErr)
+		p.Res.Duration = time.Since(p.started)
+		p.Res.TimedOut = p.timedOut
+
+		if p.auditSink != nil {
+			p.writeAudit()
+		}
+	})
+
+	return p.waitErr
 }
 
 // NewCmd initializes a Cmd with default settings.
@@ -59,35 +159,128 @@ func NewCmd() *Cmd {
 	return &cmd
 }
 
-// Wait wraps exec.Wait() and ensures that the buffers are flushed after waiting.
+// Wait wraps exec.Wait() for the command started with .Start() and ensures that the buffers are flushed after waiting.
 func (c *Cmd) Wait() error {
-	err := c.Cmd.Wait()
-
-	// call the cancel function to always release the resources associated with the context
-	if c.CancelFunc != nil {
-		c.CancelFunc()
+	proc := c.process()
+	if proc == nil {
+		return errors.New("execmd: command not started")
 	}
 
-	c.Cmd.Stderr.(*prefixedStream).Close()
-	c.Cmd.Stdout.(*prefixedStream).Close()
-	return err
+	return proc.Wait()
 }
 
 // Run is exec.Run() wrapper: runs command and blocks until it finishes, with an optional timeout
 func (c *Cmd) Run(command string, timeout ...time.Duration) (CmdRes, error) {
-	res, err := c.Start(command, timeout...)
+	return c.RunContext(context.Background(), command, timeout...)
+}
+
+// RunContext runs the command like .Run(), the command is killed when ctx is done.
+func (c *Cmd) RunContext(ctx context.Context, command string, timeout ...time.Duration) (CmdRes, error) {
+	proc, err := c.start(ctx, command, c.startOptions(), timeout...)
+	c.setProcess(proc)
 	if err != nil {
-		return res, err
+		return proc.Res, err
 	}
 
-	err = c.Wait()
-	return res, err
+	err = proc.Wait()
+	return proc.Res, err
 }
 
 // Start initializes the system shell and output buffers, and starts the command.
+// Use .Wait() to wait for the command, or .StartProcess() to start commands concurrently.
 func (c *Cmd) Start(command string, timeout ...time.Duration) (CmdRes, error) {
+	proc, err := c.start(context.Background(), command, c.startOptions(), timeout...)
+	c.setProcess(proc)
+	return proc.Res, err
+}
+
+// StartProcess starts the command like .Start(), but returns its own Process to wait for.
+// The process is nil on error, unlike .Cmd and .CancelFunc it is not saved to Cmd.
+func (c *Cmd) StartProcess(command string, timeout ...time.Duration) (*Process, error) {
+	proc, err := c.start(context.Background(), command, c.startOptions(), timeout...)
+	if err != nil {
+		return nil, err
+	}
+
+	return proc, nil
+}
+
+// setProcess saves the process started with .Start() or .Run() for the .Wait() method.
+func (c *Cmd) setProcess(proc *Process) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.proc = proc
+	if proc != nil {
+		c.Cmd = proc.Cmd
+		c.CancelFunc = proc.cancel
+	}
+}
+
+// process returns the process started with .Start().
+func (c *Cmd) process() *Process {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.proc
+}
+
+// startOptions customize a single command start, unlike Cmd fields shared by all the starts.
+type startOptions struct {
+	interactive bool
+	// display is printed instead of the command
+	display string
+	// onKill is called when the command is killed on timeout or cancel, Wait returns after it completes,
+	// so it must not block. onExit is called when the command exits, after onKill.
+	onKill func()
+	onExit func()
+	// mapErr replaces the command error, nil on success, when it completes, e.g. to classify it by the stderr output
+	mapErr func(err error, stderrTail []string) error
+	// stdin is read by non-interactive commands
+	stdin io.Reader
+	// become wraps the command to run as another user
+	become *Become
+	// redact lists secrets masked in the output
+	redact []string
+	// observe gets stdout and stderr output as it's written
+	observe func(data []byte)
+	// host is the host name of JSON output events, empty for local commands
+	host string
+	// recorder records the displayed output
+	recorder *Recorder
+	// audit writes the audit record of the command, auditCommand and auditCwd are recorded instead of
+	// the local command and working directory, e.g. for ssh commands
+	audit        AuditSink
+	auditCommand string
+	auditCwd     string
+	// sshUser and becomeUser are the remote and the become users of the audit record
+	sshUser    string
+	becomeUser string
+}
+
+// startOptions returns default start options from Cmd fields.
+func (c *Cmd) startOptions() startOptions {
+	opts := startOptions{interactive: c.Interactive, become: c.Become, recorder: c.Recorder, audit: c.Audit}
+	if c.Become != nil {
+		opts.becomeUser = c.Become.user()
+	}
+	return opts
+}
+
+// start initializes the system shell and output buffers, and starts the command.
+// The command is killed when ctx is done or the timeout is exceeded.
+// The returned process is never nil, so its output buffers are available even on error.
+func (c *Cmd) start(ctx context.Context, command string, opts startOptions, timeout ...time.Duration) (*Process, error) {
+	proc := &Process{
+		exited: make(chan struct{}),
+		killed: make(chan struct{}),
+		onKill: opts.onKill,
+		onExit: opts.onExit,
+		mapErr: opts.mapErr,
+	}
+
 	args := []string{}
-	if c.Interactive {
+	if opts.interactive {
 		args = append(args, "-i")
 	}
 
@@ -95,14 +288,41 @@ func (c *Cmd) Start(command string, timeout ...time.Duration) (CmdRes, error) {
 		args = append(args, "-l")
 	}
 
+	if opts.audit != nil {
+		auditCommand := command
+		if opts.auditCommand != "" {
+			auditCommand = opts.auditCommand
+		}
+		proc.audit = newAuditRecord(auditCommand, opts)
+	}
+
+	// startErr fails the start after the output buffers are set up
+	var startErr error
+	if opts.become != nil {
+		// the password is typed into the pseudo-terminal of PTY commands
+		if opts.become.needsTTY() && !opts.interactive && !c.PTY {
+			startErr = errBecomeTTY
+		}
+
+		var password io.Reader
+		command, password = opts.become.wrap(command, !opts.interactive)
+		opts.stdin = withStdin(password, opts.stdin)
+		opts.redact = append(opts.redact, opts.become.secrets()...)
+	}
+
 	args = append(args, "-c", command)
 
 	if len(timeout) > 0 && timeout[0] > 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout[0])
-		c.CancelFunc = cancel
-		c.Cmd = exec.CommandContext(ctx, c.ShellPath, args...)
+		ctx, proc.cancel = context.WithTimeout(ctx, timeout[0])
 	} else {
-		c.Cmd = exec.Command(c.ShellPath, args...)
+		ctx, proc.cancel = context.WithCancel(ctx)
+	}
+	proc.Cmd = exec.Command(c.ShellPath, args...)
+
+	// interactive commands stay in the terminal foreground process group to read stdin,
+	// commands in a pseudo-terminal run in a session of their own
+	if !opts.interactive && !c.PTY {
+		setProcessGroup(proc.Cmd)
 	}
 
 	stdoutLogFile := log.New(os.Stdout, "", 0)
@@ -115,27 +335,131 @@ func (c *Cmd) Start(command string, timeout ...time.Duration) (CmdRes, error) {
 		stderrLogFile = log.New(bytes.NewBuffer([]byte("")), "", 0)
 	}
 
-	stdoutStream := newPrefixedStream(stdoutLogFile, c.PrefixStdout, c.RecordStdout)
-	c.Cmd.Stdout = stdoutStream
+	proc.stdout = newPrefixedStream(stdoutLogFile, c.PrefixStdout, c.RecordStdout)
+	proc.stdout.redact = opts.redact
+	proc.stdout.observe = opts.observe
 
-	stderrStream := newPrefixedStream(stderrLogFile, c.PrefixStderr, c.RecordStderr)
-	c.Cmd.Stderr = stderrStream
+	proc.stderr = newPrefixedStream(stderrLogFile, c.PrefixStderr, c.RecordStderr)
+	proc.stderr.redact = opts.redact
+	proc.stderr.observe = opts.observe
 
-	if c.Interactive {
-		c.Cmd.Stdin = os.Stdin
+	if opts.recorder != nil && !c.MuteStdout {
+		proc.stdout.record = opts.recorder.record
+	}
+	if opts.recorder != nil && !c.MuteStderr {
+		proc.stderr.record = opts.recorder.record
+	}
+
+	if c.JSONLines {
+		proc.stdout.encode = func(line string) string { return encodeEvent(opts.host, StreamStdout, line) }
+		proc.stderr.encode = func(line string) string { return encodeEvent(opts.host, StreamStderr, line) }
+	}
+
+	if !c.PTY {
+		proc.Cmd.Stdout = proc.stdout
+		proc.Cmd.Stderr = proc.stderr
+	}
+
+	// stdin is copied apart from exec.Cmd, which Wait would block on a reader outliving the process,
+	// the pseudo-terminal copies the console or stdin on its own
+	var stdinPipe io.WriteCloser
+	switch {
+	case c.PTY:
+	case opts.interactive:
+		proc.Cmd.Stdin = os.Stdin
+	case opts.stdin != nil:
+		var err error
+		if stdinPipe, err = proc.Cmd.StdinPipe(); err != nil && startErr == nil {
+			startErr = err
+		}
 	}
 
 	if !c.MuteCmd {
-		fmt.Printf("%s%s\n", c.PrefixCmd, colorStrong(command))
+		display := command
+		if opts.display != "" {
+			display = opts.display
+		}
+		if c.JSONLines {
+			display = encodeEvent(opts.host, StreamCmd, display) + "\n"
+		} else {
+			display = fmt.Sprintf("%s%s\n", c.PrefixCmd, colorStrong(display))
+		}
+		fmt.Print(display)
+		if opts.recorder != nil {
+			opts.recorder.record(display)
+		}
 	}
 
-	err := c.Cmd.Start()
+	proc.Res = CmdRes{
+		Stdout: proc.stdout.Get(),
+		Stderr: proc.stderr.Get(),
+	}
+
+	proc.started = time.Now()
+	err := startErr
+	if err == nil && c.PTY {
+		err = proc.startPTY(opts.interactive, opts.stdin)
+	} else if err == nil {
+		err = proc.Cmd.Start()
+	}
+	if err != nil {
+		if stdinPipe != nil {
+			stdinPipe.Close()
+		}
+		proc.cancel()
+		close(proc.killed)
+		return proc, err
+	}
+	// only the commands which started are audited
+	if proc.audit != nil {
+		proc.auditSink = opts.audit
+	}
+
+	if stdinPipe != nil {
+		go func() {
+			io.Copy(stdinPipe, opts.stdin)
+			stdinPipe.Close()
+		}()
+	}
+
+	go proc.killOnDone(ctx)
+
+	return proc, nil
+}
+
+// killOnDone kills the process with all its children when ctx is done before the process exits,
+// so the children don't keep the output pipes open and Wait returns without delay.
+func (p *Process) killOnDone(ctx context.Context) {
+	defer close(p.killed)
 
-	res := CmdRes{
-		Stdout: stdoutStream.Get(),
-		Stderr: stderrStream.Get(),
+	select {
+	case <-p.exited:
+	case <-ctx.Done():
+		select {
+		case <-p.exited:
+		default:
+			p.interrupted = true
+			p.timedOut = ctx.Err() == context.DeadlineExceeded
+			killProcessGroup(p.Cmd)
+			if p.onKill != nil {
+				p.onKill()
+			}
+		}
 	}
-	return res, err
+}
+
+// exitCode extracts the exit code of a command from its error: 0 on success, -1 if unknown.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
 }
 
 // findPath finds first available shell path from a given list of paths.
@@ -150,3 +474,10 @@ func findPath(paths []string) (string, error) {
 
 	return "", fmt.Errorf("no valid shell found in path list %s: %w", paths, err)
 }
+
+// CancelOnInterrupt returns a copy of ctx which is canceled on interrupt (Ctrl-C) or SIGTERM,
+// for the ...Context() run methods. Commands in their own process group don't get the terminal interrupt,
+// so without it they keep running after the program exits. Call stop to restore the default signal behavior.
+func CancelOnInterrupt(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,685 @@
+// Command execmd runs shell commands locally, on a remote host or on a cluster of hosts over ssh,
+// with the output of every host prefixed by the host name.
+//
+// Usage:
+//
+//	execmd [flags] [--] command...
+//
+// Examples:
+//
+//	execmd -- ls -la
+//	execmd -H web01 -u deploy -- uptime
+//	execmd -H 'web[01-10]' -p 20 --timeout 30s -- uptime
+//	execmd -i hosts.ini -H 'webservers:!web03' -format aggregate -- uname -r
+//	execmd -H 'web[01-10]' -format jsonl -- df -h / | jq .
+//	execmd -H 'web[01-10]' -q -report markdown -- systemctl restart nginx 2> report.md
+//	execmd -H 'web[01-10]' -junit smoke.xml -- curl -fsS localhost/health
+//	execmd -H 'web[01-10]' -record deploy.cast -- ./deploy.sh
+//	execmd -replay deploy.cast
+//	execmd -H 'web[01-10]' -audit syslog -- systemctl restart nginx
+//	execmd -H 'web[01-10]' -script migrate.sh -- --dry-run
+//	execmd -H 'web[01-10]' -shell
+//
+// The exit code is the exit code of the command for local and single host runs.
+// Cluster runs exit with 0 if the command succeeded on all the hosts, 1 if it failed, timed out
+// or was skipped on any host, and 3 if some hosts were unreachable and the command succeeded on the others.
+// Invalid flags exit with 2. On Ctrl-C or SIGTERM the command is killed on all the hosts,
+// the results so far are printed and execmd exits with 130.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+	"golang.org/x/term"
+)
+
+// Exit codes of cluster runs and usage errors
+const (
+	exitOK          = 0
+	exitFailed      = 1
+	exitUsage       = 2
+	exitUnreachable = 3
+	// exitInterrupted is the shell exit code of commands killed by SIGINT
+	exitInterrupted = 130
+)
+
+// Output formats: the prefixed output as it is written, the output grouped by hosts after the run,
+// result records as a JSON array or JSON Lines after the run, and the output lines as JSON events
+const (
+	formatStream    = "stream"
+	formatAggregate = "aggregate"
+	formatJSON      = "json"
+	formatJSONLines = "jsonl"
+	formatEvents    = "events"
+)
+
+// formats lists the output formats of runs, the shell supports the stream and aggregate formats only
+var formats = []string{formatStream, formatAggregate, formatJSON, formatJSONLines, formatEvents}
+
+// Formats of the cluster report printed after cluster runs
+const (
+	reportText     = "text"
+	reportMarkdown = "markdown"
+	reportHTML     = "html"
+)
+
+var reportFormats = []string{reportText, reportMarkdown, reportHTML}
+
+// errFlags is returned if the flags fail to parse, the flag set prints the error with the usage
+var errFlags = errors.New("invalid flags")
+
+// auditSyslog is the -audit value sending the audit records to syslog
+const auditSyslog = "syslog"
+
+// historyFile is the default history of the cluster shell in the home directory
+const historyFile = ".execmd_history"
+
+// stringList is a flag which could be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// options are the command line flags
+type options struct {
+	hosts     stringList
+	inventory string
+
+	parallel       int
+	serial         bool
+	stopOnError    bool
+	timeout        time.Duration
+	clusterTimeout time.Duration
+	template       bool
+
+	user       string
+	port       string
+	key        string
+	jump       string
+	sshOptions stringList
+	cwd        string
+	env        stringList
+
+	become        bool
+	becomeUser    string
+	becomeMethod  string
+	askBecomePass bool
+
+	interactive bool
+	pty         bool
+	login       bool
+	quiet       bool
+	format      string
+	report      string
+	junit       string
+
+	script  string
+	shell   bool
+	history string
+
+	record string
+	replay string
+	audit  string
+
+	// args are the arguments after the flags: the command, or the script arguments
+	args []string
+	// recorder records the output to the -record file
+	recorder *execmd.Recorder
+	// auditSink writes the audit records to the -audit file or syslog
+	auditSink auditSink
+}
+
+// auditSink is the audit file or syslog sink, it keeps the write errors
+type auditSink interface {
+	execmd.AuditSink
+	io.Closer
+	Err() error
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs execmd with the command line arguments and returns the exit code.
+// The command output goes to the process stdout and stderr, reports go to stdout and stderr.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, err := parseArgs(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if errors.Is(err, errFlags) {
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "execmd: %v\n", err)
+		return exitUsage
+	}
+
+	if opts.replay != "" {
+		return runReplay(opts.replay, stdout, stderr)
+	}
+
+	become, err := opts.newBecome(stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "execmd: %v\n", err)
+		return exitUsage
+	}
+
+	if opts.audit != "" {
+		if err := opts.openAudit(); err != nil {
+			fmt.Fprintf(stderr, "execmd: %v\n", err)
+			return exitFailed
+		}
+		// the commands ran anyway, so a failed audit write is reported without changing the exit code
+		defer func() {
+			if err := opts.auditSink.Err(); err != nil {
+				fmt.Fprintf(stderr, "execmd: failed to write the audit log: %v\n", err)
+			}
+			opts.auditSink.Close()
+		}()
+	}
+
+	if opts.record != "" {
+		if opts.recorder, err = execmd.CreateRecording(opts.record, "execmd "+strings.Join(args, " ")); err != nil {
+			fmt.Fprintf(stderr, "execmd: %v\n", err)
+			return exitFailed
+		}
+		defer func() {
+			if err := opts.recorder.Close(); err != nil {
+				fmt.Fprintf(stderr, "execmd: failed to write the recording: %v\n", err)
+			}
+		}()
+	}
+
+	var cluster *execmd.ClusterSSHCmd
+	if len(opts.hosts) > 0 || opts.inventory != "" {
+		if cluster, err = opts.newCluster(become); err != nil {
+			fmt.Fprintf(stderr, "execmd: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	// the shell kills the running command on Ctrl-C and keeps reading the next ones
+	if opts.shell {
+		return runShell(opts, cluster, stdin, stdout, stderr)
+	}
+
+	// the commands run in their own process groups, so the signals don't reach them:
+	// they are killed, and the deferred recording and audit log are closed before exit
+	ctx, stop := execmd.CancelOnInterrupt(context.Background())
+	defer stop()
+
+	// the command is joined like ssh joins its arguments
+	command := strings.Join(opts.args, " ")
+
+	// the output printed after the run, e.g. the aggregated output and the summary, is recorded too
+	if opts.recorder != nil {
+		stdout = io.MultiWriter(stdout, opts.recorder)
+		stderr = io.MultiWriter(stderr, opts.recorder)
+	}
+
+	var code int
+	switch {
+	case cluster == nil:
+		code = runLocal(ctx, opts, command, become, stdout, stderr)
+	case len(cluster.Cmds) == 1 && opts.inventory == "":
+		code = runHost(ctx, opts, cluster, command, stdout, stderr)
+	default:
+		code = runCluster(ctx, opts, cluster, command, stdout, stderr)
+	}
+
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	return code
+}
+
+// parseArgs parses the flags, the remaining arguments are saved to .args
+func parseArgs(args []string, stderr io.Writer) (*options, error) {
+	opts := &options{}
+
+	fs := flag.NewFlagSet("execmd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: execmd [flags] [--] command...\n\n"+
+			"Runs the command locally, or over ssh on the hosts given with -H or selected from the inventory.\n"+
+			"Exit codes: the command exit code for local and single host runs; for clusters\n"+
+			"0 if all the hosts succeeded, 1 if the command failed on any host, 3 if hosts were unreachable.\n\n"+
+			"Flags:\n")
+		fs.PrintDefaults()
+	}
+
+	fs.Var(&opts.hosts, "H", "hosts as nodeset patterns, e.g. 'web[01-10]!web03', or the inventory selection with -i (repeatable)")
+	fs.StringVar(&opts.inventory, "i", "", "inventory file (INI, YAML or JSON), selects all the hosts unless -H is given")
+	fs.IntVar(&opts.parallel, "p", 0, "maximum number of hosts running at once, all the hosts if 0")
+	fs.BoolVar(&opts.serial, "serial", false, "run the command on one host after another")
+	fs.BoolVar(&opts.stopOnError, "stop-on-error", false, "stop the remaining hosts on the first error")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "command timeout on every host, e.g. 30s")
+	fs.DurationVar(&opts.clusterTimeout, "cluster-timeout", 0, "deadline of the whole cluster run")
+	fs.BoolVar(&opts.template, "template", false, "render the command for every host as a Go template, e.g. {{.Host}}")
+
+	fs.StringVar(&opts.user, "u", "", "ssh user")
+	fs.StringVar(&opts.port, "port", "", "ssh port")
+	fs.StringVar(&opts.key, "k", "", "ssh private key file")
+	fs.StringVar(&opts.jump, "J", "", "ssh jump host")
+	fs.Var(&opts.sshOptions, "o", "ssh option, e.g. StrictHostKeyChecking=accept-new (repeatable)")
+	fs.StringVar(&opts.cwd, "cwd", "", "remote working directory")
+	fs.Var(&opts.env, "e", "remote environment variable as NAME=VALUE (repeatable)")
+
+	fs.BoolVar(&opts.become, "b", false, "run the command as root with sudo")
+	fs.StringVar(&opts.becomeUser, "become-user", "", "run the command as the user, implies -b")
+	fs.StringVar(&opts.becomeMethod, "become-method", "", "sudo, su or doas, implies -b")
+	fs.BoolVar(&opts.askBecomePass, "K", false, "ask for the become password, implies -b")
+
+	fs.BoolVar(&opts.interactive, "interactive", false, "connect the command to the terminal (ssh -t for remote commands)")
+	fs.BoolVar(&opts.pty, "pty", false, "run the local command in a pseudo-terminal")
+	fs.BoolVar(&opts.login, "login", false, "run the local command in a login shell")
+	fs.BoolVar(&opts.quiet, "q", false, "don't print the commands and the cluster summary")
+	fs.StringVar(&opts.format, "format", "", "output format: stream (default), aggregate grouping hosts with the same output,\n"+
+		"json or jsonl result records with exit codes and durations, events as JSON lines of output")
+
+	fs.StringVar(&opts.report, "report", "", "print the report table of the cluster run to stderr instead of the summary:\n"+
+		"text, markdown or html")
+
+	fs.StringVar(&opts.junit, "junit", "", "write the JUnit XML report of the cluster run to the file, a test case per host")
+
+	fs.StringVar(&opts.script, "script", "", "local script file to run on the hosts, the arguments are passed to the script")
+	fs.BoolVar(&opts.shell, "shell", false, "start the interactive cluster shell")
+	fs.StringVar(&opts.history, "history", "", "history file of the cluster shell, ~/"+historyFile+" by default")
+
+	fs.StringVar(&opts.record, "record", "", "record the displayed output to the asciicast v2 file")
+	fs.StringVar(&opts.replay, "replay", "", "replay the asciicast v2 file with the original timing")
+	fs.StringVar(&opts.audit, "audit", "", "append audit records of the commands to the file, or send them to the local syslog with \"syslog\"")
+
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	} else if err != nil {
+		return nil, errFlags
+	}
+
+	opts.args = fs.Args()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// validate checks the flags which can't be used together
+func (o *options) validate() error {
+	remote := len(o.hosts) > 0 || o.inventory != ""
+
+	switch {
+	case o.replay != "" && (remote || o.script != "" || len(o.args) > 0 || o.record != ""):
+		return errors.New("-replay doesn't take hosts, a command or other recordings")
+	case o.replay != "":
+		return nil
+	case o.format != "" && !containsString(formats, o.format):
+		return fmt.Errorf("unknown format %q, expected one of %s", o.format, strings.Join(formats, ", "))
+	case o.report != "" && !containsString(reportFormats, o.report):
+		return fmt.Errorf("unknown report format %q, expected one of %s", o.report, strings.Join(reportFormats, ", "))
+	case o.report != "" && (!remote || o.shell):
+		return errors.New("-report requires hosts and can't be used with -shell")
+	case o.junit != "" && (!remote || o.shell):
+		return errors.New("-junit requires hosts and can't be used with -shell")
+	case o.shell && o.format != "" && o.format != formatStream && o.format != formatAggregate:
+		return fmt.Errorf("-shell doesn't support the %s format", o.format)
+	case o.shell && !remote:
+		return errors.New("-shell requires hosts")
+	case o.shell && (len(o.args) > 0 || o.script != ""):
+		return errors.New("-shell doesn't take a command or a script")
+	case o.script != "" && !remote:
+		return errors.New("-script requires hosts")
+	case o.script == "" && !o.shell && len(o.args) == 0:
+		return errors.New("command is required")
+	case o.serial && o.parallel > 0:
+		return errors.New("-serial and -p can't be used together")
+	case o.parallel < 0:
+		return errors.New("-p must not be negative")
+	case o.pty && remote:
+		return errors.New("-pty is supported for local commands only, use -interactive for remote commands")
+	}
+
+	for _, env := range o.env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("invalid environment variable %q, expected NAME=VALUE", env)
+		}
+	}
+
+	return nil
+}
+
+// openAudit opens the audit sink of -audit, the file or syslog
+func (o *options) openAudit() error {
+	if o.audit == auditSyslog {
+		sink, err := execmd.NewSyslogAuditSink("", "")
+		if err != nil {
+			return err
+		}
+		o.auditSink = sink
+		return nil
+	}
+
+	sink, err := execmd.OpenAuditFile(o.audit)
+	if err != nil {
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	o.auditSink = sink
+	return nil
+}
+
+// newBecome returns the become settings, the password is read from the terminal with -K
+func (o *options) newBecome(stderr io.Writer) (*execmd.Become, error) {
+	if !o.become && o.becomeUser == "" && o.becomeMethod == "" && !o.askBecomePass {
+		return nil, nil
+	}
+
+	become := &execmd.Become{Method: execmd.BecomeMethod(o.becomeMethod), User: o.becomeUser}
+	switch become.Method {
+	case "", execmd.BecomeSudo, execmd.BecomeSu, execmd.BecomeDoas:
+	default:
+		return nil, fmt.Errorf("unknown become method %q", o.becomeMethod)
+	}
+
+	if o.askBecomePass {
+		fmt.Fprint(stderr, "BECOME password: ")
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the become password: %w", err)
+		}
+		become.Password = string(password)
+	}
+
+	return become, nil
+}
+
+// newCluster returns the cluster of the hosts given with -H or selected from the inventory, configured with the flags
+func (o *options) newCluster(become *execmd.Become) (*execmd.ClusterSSHCmd, error) {
+	var cluster *execmd.ClusterSSHCmd
+	if o.inventory != "" {
+		inv, err := execmd.LoadInventory(o.inventory)
+		if err != nil {
+			return nil, err
+		}
+
+		expr := strings.Join(o.hosts, ",")
+		if expr == "" {
+			expr = "all"
+		}
+		if cluster, err = inv.NewClusterSSHCmd(expr); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, pattern := range o.hosts {
+			if _, err := execmd.ExpandHosts(pattern); err != nil {
+				return nil, err
+			}
+		}
+		cluster = execmd.NewClusterSSHCmd(o.hosts)
+	}
+
+	if len(cluster.Cmds) == 0 {
+		return nil, errors.New("no hosts match")
+	}
+
+	cluster.MaxParallel = o.parallel
+	cluster.StopOnError = o.stopOnError
+	cluster.Timeout = o.clusterTimeout
+	cluster.Template = o.template
+	cluster.Cwd = o.cwd
+	cluster.Become = become
+
+	for i := range cluster.Cmds {
+		o.configureSSH(&cluster.Cmds[i].SSHCmd, become)
+	}
+
+	return cluster, nil
+}
+
+// configureSSH applies the ssh flags, settings from the inventory are kept unless the flags are given
+func (o *options) configureSSH(ssh *execmd.SSHCmd, become *execmd.Become) {
+	if o.user != "" {
+		ssh.User = o.user
+	}
+	if o.port != "" {
+		ssh.Port = o.port
+	}
+	if o.key != "" {
+		ssh.KeyPath = o.key
+	}
+	if o.jump != "" {
+		ssh.JumpHost = o.jump
+	}
+	ssh.Options = append(ssh.Options, o.sshOptions...)
+
+	if len(o.env) > 0 && ssh.Env == nil {
+		ssh.Env = map[string]string{}
+	}
+	for _, env := range o.env {
+		i := strings.Index(env, "=")
+		ssh.Env[env[:i]] = env[i+1:]
+	}
+
+	if o.cwd != "" {
+		ssh.Cwd = o.cwd
+	}
+	ssh.Become = become
+	ssh.Interactive = o.interactive
+	o.configureCmd(ssh.Cmd)
+}
+
+// configureCmd applies the output flags, the output printed after the run is muted while it runs
+func (o *options) configureCmd(cmd *execmd.Cmd) {
+	cmd.MuteCmd = o.quiet
+	cmd.JSONLines = o.format == formatEvents
+	cmd.Recorder = o.recorder
+	cmd.Audit = o.auditSink
+
+	switch o.format {
+	case formatAggregate, formatJSON, formatJSONLines:
+		cmd.MuteCmd = true
+		cmd.MuteStdout = true
+		cmd.MuteStderr = true
+	}
+}
+
+// runLocal runs the command in the local shell, the command is killed when ctx is done
+func runLocal(ctx context.Context, opts *options, command string, become *execmd.Become, stdout, stderr io.Writer) int {
+	cmd := execmd.NewCmd()
+	cmd.Interactive = opts.interactive
+	cmd.PTY = opts.pty
+	cmd.LoginShell = opts.login
+	cmd.Become = become
+	opts.configureCmd(cmd)
+
+	res, err := cmd.RunContext(ctx, command, opts.timeout)
+	return reportOne(opts, execmd.ClusterRes{Host: "localhost", Err: err, Res: res}, stdout, stderr)
+}
+
+// runHost runs the command or the script on the single host of the cluster, so the command template
+// and the cluster deadline apply, and returns the exit code of the command
+func runHost(ctx context.Context, opts *options, cluster *execmd.ClusterSSHCmd, command string, stdout, stderr io.Writer) int {
+	results, err := runOnHosts(ctx, opts, cluster, command)
+	if results == nil {
+		fmt.Fprintf(stderr, "execmd: %v\n", err)
+		return exitUsage
+	}
+
+	return reportOne(opts, results[0], stdout, stderr)
+}
+
+// runCluster runs the command or the script on all the hosts
+func runCluster(ctx context.Context, opts *options, cluster *execmd.ClusterSSHCmd, command string, stdout, stderr io.Writer) int {
+	results, err := runOnHosts(ctx, opts, cluster, command)
+	if results == nil {
+		fmt.Fprintf(stderr, "execmd: %v\n", err)
+		return exitFailed
+	}
+
+	writeResults(opts, results, stdout)
+
+	if opts.junit != "" {
+		name := command
+		if opts.script != "" {
+			name = opts.script
+		}
+		if err := writeJUnit(opts.junit, name, results); err != nil {
+			fmt.Fprintf(stderr, "execmd: %v\n", err)
+		}
+	}
+
+	summary := execmd.Summarize(results)
+	if opts.report != "" {
+		writeReport(opts, execmd.NewClusterReport(results), stderr)
+	} else if !opts.quiet {
+		fmt.Fprintln(stderr, summary)
+	}
+
+	switch {
+	case len(summary.Failed) > 0 || len(summary.Timeout) > 0 || len(summary.Skipped) > 0:
+		return exitFailed
+	case len(summary.Unreachable) > 0:
+		return exitUnreachable
+	case err != nil:
+		return exitFailed
+	}
+	return exitOK
+}
+
+// runOnHosts runs the command or the script on the hosts in parallel, or one by one with -serial,
+// the hosts are killed when ctx is done. The results are nil if nothing was started.
+func runOnHosts(ctx context.Context, opts *options, cluster *execmd.ClusterSSHCmd, command string) ([]execmd.ClusterRes, error) {
+	switch {
+	case opts.script != "":
+		script, err := execmd.LoadScript(opts.script, opts.args...)
+		if err != nil {
+			return nil, err
+		}
+		return cluster.RunScriptContext(ctx, script, opts.timeout)
+	case opts.serial:
+		return cluster.RunOneByOneContext(ctx, command, opts.timeout)
+	default:
+		return cluster.RunContext(ctx, command, opts.timeout)
+	}
+}
+
+// runReplay replays the recording to stdout
+func runReplay(path string, stdout, stderr io.Writer) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "execmd: %v\n", err)
+		return exitFailed
+	}
+	defer f.Close()
+
+	if err := execmd.Replay(stdout, f); err != nil {
+		fmt.Fprintf(stderr, "execmd: %v\n", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// runShell starts the interactive cluster shell, it aggregates the output unless the stream format is selected
+func runShell(opts *options, cluster *execmd.ClusterSSHCmd, stdin io.Reader, stdout, stderr io.Writer) int {
+	shell := execmd.NewClusterShell(cluster)
+	shell.Serial = opts.serial
+	shell.Stream = opts.format == formatStream
+	shell.Timeout = opts.timeout
+
+	shell.HistoryFile = opts.history
+	if home, err := os.UserHomeDir(); err == nil && shell.HistoryFile == "" {
+		shell.HistoryFile = filepath.Join(home, historyFile)
+	}
+
+	if err := shell.Run(stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "execmd: %v\n", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// reportOne prints the result of a local or single host run in the output format and returns the exit code of the command
+func reportOne(opts *options, res execmd.ClusterRes, stdout, stderr io.Writer) int {
+	writeResults(opts, []execmd.ClusterRes{res}, stdout)
+
+	if res.Err == nil {
+		return exitOK
+	}
+
+	// the command output explains its own failure, other errors are printed
+	if res.Res.ExitCode <= 0 {
+		fmt.Fprintf(stderr, "execmd: %v\n", res.Err)
+		return exitFailed
+	}
+	return res.Res.ExitCode
+}
+
+// writeResults prints the results in the output format, if the format is printed after the run
+func writeResults(opts *options, results []execmd.ClusterRes, stdout io.Writer) {
+	switch opts.format {
+	case formatAggregate:
+		execmd.WriteAggregated(stdout, execmd.AggregateResults(results))
+	case formatJSON:
+		execmd.WriteJSON(stdout, results)
+	case formatJSONLines:
+		execmd.WriteJSONLines(stdout, results)
+	}
+}
+
+// writeReport prints the cluster report in the report format
+func writeReport(opts *options, report execmd.ClusterReport, w io.Writer) {
+	var err error
+	switch opts.report {
+	case reportMarkdown:
+		err = report.WriteMarkdown(w)
+	case reportHTML:
+		err = report.WriteHTML(w)
+	default:
+		err = report.WriteText(w)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "execmd: failed to write the report: %v\n", err)
+	}
+}
+
+// writeJUnit writes the JUnit XML report of the results to the file
+func writeJUnit(path, name string, results []execmd.ClusterRes) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write the JUnit report: %w", err)
+	}
+
+	err = execmd.WriteJUnit(f, name, results)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the JUnit report: %w", err)
+	}
+	return nil
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
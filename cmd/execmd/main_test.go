@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dummyHosts are the hosts of the live runs, both are the local machine
+var dummyHosts = []string{"localhost", "127.0.0.1"}
+
+func runArgs(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr strings.Builder
+	code := run(args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Local(t *testing.T) {
+	if code, _, stderr := runArgs(t, "--", "true"); code != exitOK {
+		t.Errorf("Unexpected exit code: %d, %s", code, stderr)
+	}
+	if code, _, _ := runArgs(t, "-q", "exit", "7"); code != 7 {
+		t.Errorf("Expected the command exit code, got: %d", code)
+	}
+
+	code, stdout, _ := runArgs(t, "-format", "aggregate", "--", "echo", "hello")
+	if code != exitOK || !strings.Contains(stdout, "localhost (1)") || !strings.Contains(stdout, "hello\n") {
+		t.Errorf("Unexpected aggregated output: %d, %s", code, stdout)
+	}
+
+	code, _, stderr := runArgs(t, "-timeout", "100ms", "--", "sleep", "5")
+	if code != exitFailed || !strings.Contains(stderr, "killed") {
+		t.Errorf("Expected timeout error, got: %d, %s", code, stderr)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-no-such-flag", "true"},
+		{"-format", "xml", "true"},
+		{"-shell"},
+		{"-H", "host-01", "-shell", "uptime"},
+		{"-H", "host-01", "-serial", "-p", "2", "true"},
+		{"-H", "host-[01-", "true"},
+		{"-H", "host-01", "-e", "NOVALUE", "true"},
+		{"-become-method", "pkexec", "true"},
+		{"-H", "host-01", "-shell", "-format", "json"},
+		{"-H", "host-01", "-report", "pdf", "true"},
+		{"-junit", "report.xml", "true"},
+		{"-replay", "session.cast", "true"},
+	} {
+		if code, _, _ := runArgs(t, args...); code != exitUsage {
+			t.Errorf("Expected usage error for %q, got: %d", args, code)
+		}
+	}
+
+	if code, _, _ := runArgs(t, "-h"); code != exitOK {
+		t.Errorf("Unexpected exit code of help: %d", code)
+	}
+}
+
+func TestRun_Host(t *testing.T) {
+	if code, _, stderr := runArgs(t, "-H", "localhost", "--", "exit", "5"); code != 5 {
+		t.Errorf("Expected the remote exit code, got: %d, %s", code, stderr)
+	}
+
+	script := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(script, []byte(`echo "arg: $1"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr := runArgs(t, "-H", "localhost", "-format", "aggregate", "-script", script, "--", "two words")
+	if code != exitOK || !strings.Contains(stdout, "arg: two words\n") {
+		t.Errorf("Unexpected script output: %d, %s, %s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runArgs(t, "-H", "localhost", "-format", "aggregate", "-template", "--", "echo", "host={{.Host}}")
+	if code != exitOK || !strings.Contains(stdout, "host=localhost\n") {
+		t.Errorf("Unexpected template output: %d, %s, %s", code, stdout, stderr)
+	}
+
+	start := time.Now()
+	code, _, stderr = runArgs(t, "-H", "localhost", "-cluster-timeout", "200ms", "--", "sleep", "5")
+	if code == exitOK || !strings.Contains(stderr, "cluster timeout exceeded") || time.Since(start) > 3*time.Second {
+		t.Errorf("Expected cluster timeout, got: %d, %s after %s", code, stderr, time.Since(start))
+	}
+}
+
+func TestRun_Cluster(t *testing.T) {
+	code, stdout, stderr := runArgs(t, "-H", dummyHosts[0], "-H", dummyHosts[1], "-p", "1", "-timeout", "5s", "-format", "aggregate", "--", "echo", "hi")
+	if code != exitOK {
+		t.Errorf("Unexpected exit code: %d, %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "localhost,127.0.0.1 (2)") || !strings.Contains(stderr, "ok: 2 localhost,127.0.0.1") {
+		t.Errorf("Unexpected output: %s, %s", stdout, stderr)
+	}
+
+	if code, _, _ := runArgs(t, "-H", dummyHosts[0], "-H", dummyHosts[1], "-serial", "-q", "-template", "--", "test", "{{.Index}}", "-eq", "0"); code != exitFailed {
+		t.Errorf("Expected failed exit code, got: %d", code)
+	}
+
+	// a pattern with spaces is a single -H value
+	code, _, stderr = runArgs(t, "-H", dummyHosts[0]+", "+dummyHosts[1], "--", "true")
+	if code != exitOK || !strings.Contains(stderr, "ok: 2 localhost,127.0.0.1") {
+		t.Errorf("Unexpected result of the host list: %d, %s", code, stderr)
+	}
+
+	code, _, stderr = runArgs(t, "-H", dummyHosts[0], "-H", "127.0.0.1:1", "--", "true")
+	if code != exitUnreachable || !strings.Contains(stderr, "unreachable: 1 127.0.0.1:1") {
+		t.Errorf("Expected unreachable exit code, got: %d, %s", code, stderr)
+	}
+}
+
+func TestRun_Inventory(t *testing.T) {
+	inventory := filepath.Join(t.TempDir(), "hosts.ini")
+	data := "[web]\nlocalhost\n127.0.0.1\nlocalhost:22\n[db]\ndb01\n"
+	if err := os.WriteFile(inventory, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, stderr := runArgs(t, "-i", inventory, "-H", "web:!127.0.0.1", "--", "true")
+	if code != exitOK || !strings.Contains(stderr, "ok: 2 localhost,localhost:22") {
+		t.Errorf("Unexpected result: %d, %s", code, stderr)
+	}
+
+	if code, _, _ := runArgs(t, "-i", inventory, "-H", "nothing", "--", "true"); code != exitUsage {
+		t.Errorf("Expected usage error, got: %d", code)
+	}
+}
+
+func TestRun_Shell(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"-H", dummyHosts[0], "-H", dummyHosts[1], "-shell", "-history", history}, strings.NewReader("echo hi\n:quit\n"), &stdout, &stderr)
+	if code != exitOK || !strings.Contains(stdout.String(), "localhost,127.0.0.1 (2)") {
+		t.Errorf("Unexpected shell output: %d, %s, %s", code, stdout.String(), stderr.String())
+	}
+}
+
+func TestRun_JSON(t *testing.T) {
+	code, stdout, _ := runArgs(t, "-H", dummyHosts[0], "-H", "127.0.0.1:1", "-format", "json", "--", "echo", "hi")
+	if code != exitUnreachable {
+		t.Errorf("Unexpected exit code: %d", code)
+	}
+
+	var records []map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &records); err != nil {
+		t.Fatalf("Invalid JSON output %q: %v", stdout, err)
+	}
+	if len(records) != 2 || records[0]["stdout"] != "hi\n" || records[1]["status"] != "unreachable" {
+		t.Errorf("Unexpected records: %v", records)
+	}
+
+	code, stdout, _ = runArgs(t, "-format", "jsonl", "--", "exit", "4")
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &record); err != nil {
+		t.Fatalf("Invalid JSON Lines output %q: %v", stdout, err)
+	}
+	if code != 4 || record["exit_code"] != 4.0 || record["status"] != "failed" {
+		t.Errorf("Unexpected result: %d, %v", code, record)
+	}
+}
+
+func TestRun_Report(t *testing.T) {
+	code, _, stderr := runArgs(t, "-H", dummyHosts[0], "-H", dummyHosts[1], "-q", "-report", "markdown", "-template",
+		"--", "test", "{{.Index}}", "-eq", "0")
+	if code != exitFailed {
+		t.Errorf("Unexpected exit code: %d", code)
+	}
+	if !strings.Contains(stderr, "| 127.0.0.1 | **failed** | 1 |") || !strings.Contains(stderr, "2 hosts: 1 ok, 1 failed") {
+		t.Errorf("Unexpected report: %s", stderr)
+	}
+
+	if code, _, _ := runArgs(t, "-report", "text", "--", "true"); code != exitUsage {
+		t.Errorf("Expected usage error, got: %d", code)
+	}
+}
+
+func TestRun_JUnit(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "report.xml")
+	code, _, stderr := runArgs(t, "-H", dummyHosts[0], "-H", "127.0.0.1:1", "-junit", report, "--", "true")
+	if code != exitUnreachable {
+		t.Errorf("Unexpected exit code: %d, %s", code, stderr)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	if !strings.Contains(string(data), `<testsuite name="true" tests="2" failures="0" errors="1"`) ||
+		!strings.Contains(string(data), `<testcase name="127.0.0.1:1" classname="true"`) {
+		t.Errorf("Unexpected report: %s", data)
+	}
+}
+
+func TestRun_Record(t *testing.T) {
+	cast := filepath.Join(t.TempDir(), "session.cast")
+	if code, _, stderr := runArgs(t, "-record", cast, "--", "echo", "recorded"); code != exitOK {
+		t.Fatalf("Unexpected exit code: %d, %s", code, stderr)
+	}
+
+	code, stdout, stderr := runArgs(t, "-replay", cast)
+	if code != exitOK || !strings.Contains(stdout, "recorded\r\n") {
+		t.Errorf("Unexpected replay: %d, %q, %s", code, stdout, stderr)
+	}
+
+	// the output printed after the run is recorded as displayed
+	code, _, stderr = runArgs(t, "-H", dummyHosts[0], "-H", dummyHosts[1], "-format", "aggregate", "-record", cast, "--", "echo", "aggregated")
+	if code != exitOK {
+		t.Fatalf("Unexpected exit code: %d, %s", code, stderr)
+	}
+	code, stdout, stderr = runArgs(t, "-replay", cast)
+	if code != exitOK || !strings.Contains(stdout, "aggregated\r\n") || !strings.Contains(stdout, "ok: 2 localhost,127.0.0.1") {
+		t.Errorf("Unexpected replay of the aggregated output: %d, %q, %s", code, stdout, stderr)
+	}
+}
+
+func TestRun_Audit(t *testing.T) {
+	// the ssh user is the current user, so the login works as in the other tests
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audit := filepath.Join(t.TempDir(), "audit.log")
+	if code, _, stderr := runArgs(t, "-q", "-audit", audit, "-H", dummyHosts[0], "-H", dummyHosts[1], "-u", current.Username, "--", "true"); code != exitOK {
+		t.Fatalf("Unexpected exit code: %d, %s", code, stderr)
+	}
+
+	data, err := os.ReadFile(audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"command":"true"`) ||
+		!strings.Contains(lines[0], `"ssh_user":"`+current.Username+`"`) {
+		t.Errorf("Unexpected audit log: %s", data)
+	}
+
+	// a failed audit write is reported without changing the exit code
+	if _, err := os.Stat("/dev/full"); err != nil {
+		return
+	}
+	code, _, stderr := runArgs(t, "-q", "-audit", "/dev/full", "--", "true")
+	if code != exitOK || !strings.Contains(stderr, "failed to write the audit log") {
+		t.Errorf("Unexpected result of failed audit: %d, %s", code, stderr)
+	}
+}
@@ -1,9 +1,12 @@
 package execmd_test
 
 import (
+	"context"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -52,6 +55,27 @@ func TestNewCmd(t *testing.T) {
 	if !strings.Contains(res.Stderr.String(), "i-am-not-exist") {
 		t.Errorf("Unexpected error output: %s", res.Stderr.String())
 	}
+	if res.ExitCode != 127 {
+		t.Errorf("Unexpected exit code: %d", res.ExitCode)
+	}
+	if res.Duration <= 0 {
+		t.Errorf("Unexpected duration: %s", res.Duration)
+	}
+}
+
+func TestCmd_LastLineWithoutNewline(t *testing.T) {
+	cmd := execmd.NewCmd()
+	res, err := cmd.Run("printf 'one\ntwo'; printf err >&2")
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+
+	if res.Stdout.String() != "one\ntwo" {
+		t.Errorf("Unexpected stdout output: %q", res.Stdout.String())
+	}
+	if res.Stderr.String() != "err" {
+		t.Errorf("Unexpected stderr output: %q", res.Stderr.String())
+	}
 }
 
 func TestRunWithTimeout(t *testing.T) {
@@ -65,9 +89,15 @@ func TestRunWithTimeout(t *testing.T) {
 	if !strings.Contains(res.Stdout.String(), "OK") {
 		t.Errorf("Unexpected output: %s", res.Stdout.String())
 	}
+	if res.TimedOut {
+		t.Errorf("Command is reported as timed out")
+	}
 
 	// Test running a command that will be killed due to timeout
 	res, err = cmd.Run("sleep 3; echo OK", 1*time.Second)
+	if !res.TimedOut {
+		t.Errorf("Command killed on timeout is not reported as timed out")
+	}
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
@@ -82,3 +112,79 @@ func TestRunWithTimeout(t *testing.T) {
 		t.Errorf("Expected an error due to the process being killed by a timeout")
 	}
 }
+
+func TestCmd_ConcurrentRun(t *testing.T) {
+	cmd := execmd.NewCmd()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			expected := strconv.Itoa(i) + "\n"
+			res, err := cmd.Run("sleep 0.1; echo " + strconv.Itoa(i))
+			if err != nil {
+				t.Errorf("Failed to run command: %v", err)
+				return
+			}
+			if res.Stdout.String() != expected {
+				t.Errorf("Unexpected output: %s, expected %s", res.Stdout, expected)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCmd_StartProcess(t *testing.T) {
+	cmd := execmd.NewCmd()
+
+	first, err := cmd.StartProcess("sleep 0.2; echo first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cmd.StartProcess("echo second", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if first.Res.Stdout.String() != "first\n" || second.Res.Stdout.String() != "second\n" {
+		t.Errorf("Unexpected outputs: %s, %s", first.Res.Stdout, second.Res.Stdout)
+	}
+
+	cmd.ShellPath = "/no/such/shell"
+	if proc, err := cmd.StartProcess("true"); err == nil || proc != nil {
+		t.Errorf("Expected nil process and error, got: %v, %v", proc, err)
+	}
+}
+
+func TestCmd_RunSetsCmd(t *testing.T) {
+	cmd := execmd.NewCmd()
+	if _, err := cmd.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Cmd == nil || cmd.Cmd.ProcessState == nil || cmd.CancelFunc == nil {
+		t.Errorf("Run doesn't set Cmd and CancelFunc: %v", cmd.Cmd)
+	}
+}
+
+func TestCmd_RunContext(t *testing.T) {
+	cmd := execmd.NewCmd()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := cmd.RunContext(ctx, "sleep 5"); err == nil {
+		t.Error("Expected error of the canceled command")
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Command was not canceled in time: %s", time.Since(start))
+	}
+}
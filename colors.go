@@ -42,3 +42,7 @@ func colorOK(str string) string {
 func colorStrong(str string) string {
 	return fcolor.New(fcolor.Bold).SprintFunc()(str)
 }
+
+func colorWarn(str string) string {
+	return fcolor.New(fcolor.FgYellow).SprintFunc()(str)
+}
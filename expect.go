@@ -0,0 +1,328 @@
+package execmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultExpectTimeout limits waiting for the pattern of an ExpectStep without its own timeout
+const DefaultExpectTimeout = 30 * time.Second
+
+// expectBufferSize limits the unmatched output kept for pattern matching
+const expectBufferSize = 64 * 1024
+
+// ErrExpect is returned if the expected output doesn't appear, the pattern follows in the error message
+var ErrExpect = errors.New("expected output not found")
+
+// ExpectStep waits for the pattern in the command output and answers it.
+type ExpectStep struct {
+	// Pattern is a regular expression matched against stdout and stderr output since the previous match,
+	// incomplete lines are matched too, so prompts without a line break are found
+	Pattern string
+	// Response is written to stdin of the command as is, add "\n" to answer a line prompt
+	Response string
+	// Secret masks the response in the transcript and the output
+	Secret bool
+	// Timeout limits waiting for the pattern since the previous step, DefaultExpectTimeout if zero
+	Timeout time.Duration
+}
+
+// ExpectRes is the result of the command with the expect transcript.
+type ExpectRes struct {
+	CmdRes
+	// Transcript holds the command output interleaved with the responses
+	Transcript string
+}
+
+// ClusterExpectRes is the result of the command with the expect transcript on a cluster host.
+type ClusterExpectRes struct {
+	ClusterRes
+	Transcript string
+}
+
+// expecter watches the command output for the patterns of the steps in order and writes the responses to stdin,
+// stdin of the command is closed after the last response.
+type expecter struct {
+	steps    []ExpectStep
+	patterns []*regexp.Regexp
+	stdin    *io.PipeReader
+	replies  chan string
+
+	mu         sync.Mutex
+	step       int
+	pending    []byte
+	transcript bytes.Buffer
+	timer      *time.Timer
+	kill       func()
+	err        error
+	stopped    bool
+}
+
+// newExpecter compiles the step patterns, it must be started with the process.
+func newExpecter(steps []ExpectStep) (*expecter, error) {
+	e := &expecter{steps: steps, replies: make(chan string, len(steps))}
+	for _, step := range steps {
+		re, err := regexp.Compile(step.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expect pattern %q: %w", step.Pattern, err)
+		}
+		e.patterns = append(e.patterns, re)
+	}
+
+	var writer *io.PipeWriter
+	e.stdin, writer = io.Pipe()
+
+	// the replies are written apart from the output, so a command not reading stdin doesn't block its output
+	go func() {
+		for reply := range e.replies {
+			if _, err := io.WriteString(writer, reply); err != nil {
+				break
+			}
+		}
+		writer.Close()
+	}()
+
+	return e, nil
+}
+
+// processIO returns the input and output of the command connected to the expecter
+func (e *expecter) processIO() processIO {
+	pio := processIO{stdin: e.stdin, observe: e.observe, mapErr: e.finish}
+	for _, step := range e.steps {
+		if secret := strings.TrimRight(step.Response, "\r\n"); step.Secret && secret != "" {
+			pio.redact = append(pio.redact, secret)
+		}
+	}
+	return pio
+}
+
+// start starts the timeout of the first step and matches the output written so far,
+// kill is called on timeout. If the process failed to start, stdin is closed.
+func (e *expecter) start(kill func(), err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err != nil {
+		e.stop()
+		return
+	}
+
+	e.kill = kill
+	e.next()
+	e.match()
+}
+
+// next starts the timeout of the current step or closes stdin after the last one, e.mu must be locked
+func (e *expecter) next() {
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+
+	if e.step == len(e.steps) {
+		e.stop()
+		return
+	}
+
+	step := e.step
+	timeout := e.steps[step].Timeout
+	if timeout <= 0 {
+		timeout = DefaultExpectTimeout
+	}
+	e.timer = time.AfterFunc(timeout, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if e.step == step && e.err == nil {
+			e.err = fmt.Errorf("%w: %q in %s", ErrExpect, e.steps[step].Pattern, timeout)
+			e.kill()
+		}
+	})
+}
+
+// observe saves the output to the transcript and matches it.
+func (e *expecter) observe(data []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.transcript.Write(data)
+	e.pending = append(e.pending, data...)
+	e.match()
+
+	if len(e.pending) > expectBufferSize {
+		e.pending = e.pending[len(e.pending)-expectBufferSize:]
+	}
+}
+
+// match answers the steps which patterns are found in the pending output, e.mu must be locked
+func (e *expecter) match() {
+	for e.kill != nil && e.err == nil && e.step < len(e.steps) {
+		loc := e.patterns[e.step].FindIndex(e.pending)
+		if loc == nil {
+			return
+		}
+
+		step := e.steps[e.step]
+		if step.Secret {
+			e.transcript.WriteString(redactedText + "\n")
+		} else {
+			e.transcript.WriteString(step.Response)
+		}
+
+		e.replies <- step.Response
+		e.pending = e.pending[loc[1]:]
+		e.step++
+		e.next()
+	}
+}
+
+// finish stops waiting for the patterns when the command exits. The expect error replaces
+// the command error, which is caused by the kill on timeout.
+func (e *expecter) finish(err error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.step < len(e.steps) && e.err == nil {
+		e.err = fmt.Errorf("%w: %q before the command exited", ErrExpect, e.steps[e.step].Pattern)
+	}
+	e.stop()
+	// the command could exit without reading stdin to the end
+	e.stdin.Close()
+
+	if e.err != nil {
+		return e.err
+	}
+	return err
+}
+
+// stop stops the timeout and closes stdin after the replies written so far, e.mu must be locked
+func (e *expecter) stop() {
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	if !e.stopped {
+		e.stopped = true
+		close(e.replies)
+	}
+}
+
+// close stops the expecter of a process which has exited or never started, the replies are discarded
+func (e *expecter) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stop()
+	e.stdin.Close()
+}
+
+// result returns the process result with the transcript, any expecter not started is stopped
+func (e *expecter) result(res CmdRes) ExpectRes {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stop()
+
+	// secrets could be echoed in the output
+	transcript := e.transcript.String()
+	for _, secret := range e.processIO().redact {
+		transcript = strings.Replace(transcript, secret, redactedText, -1)
+	}
+
+	return ExpectRes{CmdRes: res, Transcript: transcript}
+}
+
+// RunExpect runs the command answering the prompts of the steps in order and waits for it to complete.
+// It fails with ErrExpect if a pattern doesn't appear in time, or before the command exits.
+func (c *Cmd) RunExpect(command string, steps []ExpectStep, timeout ...time.Duration) (ExpectRes, error) {
+	e, err := newExpecter(steps)
+	if err != nil {
+		return ExpectRes{}, err
+	}
+
+	pio := e.processIO()
+	opts := c.startOptions()
+	opts.interactive = false
+	opts.stdin = pio.stdin
+	opts.observe = pio.observe
+	opts.redact = pio.redact
+	opts.mapErr = func(err error, _ []string) error {
+		return e.finish(err)
+	}
+
+	proc, err := c.start(context.Background(), command, opts, timeout...)
+	e.start(proc.Cancel, err)
+	if err == nil {
+		err = proc.Wait()
+	}
+
+	return e.result(proc.Res), err
+}
+
+// RunExpect runs the remote command answering the prompts of the steps in order, see Cmd.RunExpect().
+// The remote command reads the responses from stdin, it doesn't run in a pseudo-terminal.
+func (s *SSHCmd) RunExpect(command string, steps []ExpectStep, timeout ...time.Duration) (ExpectRes, error) {
+	e, err := newExpecter(steps)
+	if err != nil {
+		return ExpectRes{}, err
+	}
+
+	proc, err := s.startExpect(context.Background(), command, e, timeout...)
+	if proc == nil {
+		return ExpectRes{}, err
+	}
+	if err == nil {
+		err = proc.Wait()
+	}
+
+	return e.result(proc.Res), err
+}
+
+// startExpect starts the remote command connected to the expecter
+func (s *SSHCmd) startExpect(ctx context.Context, command string, e *expecter, timeout ...time.Duration) (*Process, error) {
+	proc, err := s.startWithIO(ctx, command, e.processIO(), timeout...)
+
+	kill := func() {}
+	if proc != nil {
+		kill = proc.Cancel
+	}
+	e.start(kill, err)
+
+	return proc, err
+}
+
+// RunExpect runs the command on all hosts in parallel answering the prompts of the steps on every host,
+// see Cmd.RunExpect(). It returns results with the transcript per host and the first caught error.
+func (c *ClusterSSHCmd) RunExpect(command string, steps []ExpectStep, timeout ...time.Duration) ([]ClusterExpectRes, error) {
+	var expecters []*expecter
+	// the expecters of hosts which were not started or failed to start have nothing to stop them
+	defer func() {
+		for _, e := range expecters {
+			e.close()
+		}
+	}()
+
+	for range c.Cmds {
+		e, err := newExpecter(steps)
+		if err != nil {
+			return nil, err
+		}
+		expecters = append(expecters, e)
+	}
+
+	results, err := c.runEach(func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error) {
+		return ssh.startExpect(ctx, command, expecters[i], timeout...)
+	})
+
+	expectResults := make([]ClusterExpectRes, len(results))
+	for i, res := range results {
+		expectResults[i] = ClusterExpectRes{ClusterRes: res, Transcript: expecters[i].result(res.Res).Transcript}
+	}
+
+	return expectResults, err
+}
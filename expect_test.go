@@ -0,0 +1,97 @@
+package execmd_test
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+var installerSteps = []execmd.ExpectStep{
+	{Pattern: `Continue\? \[y/N\] `, Response: "y\n"},
+	{Pattern: `Password: `, Response: "secret\n", Secret: true},
+}
+
+const installer = `printf 'Continue? [y/N] '; read -r answer; printf 'Password: '; read -r pass; echo "got $answer $pass"`
+
+func TestCmd_RunExpect(t *testing.T) {
+	cmd := execmd.NewCmd()
+
+	res, err := cmd.RunExpect(installer, installerSteps)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if !strings.HasSuffix(res.Stdout.String(), "got y ********\n") {
+		t.Errorf("Unexpected stdout output: %q", res.Stdout.String())
+	}
+	if res.Transcript != "Continue? [y/N] y\nPassword: ********\ngot y ********\n" {
+		t.Errorf("Unexpected transcript: %q", res.Transcript)
+	}
+
+	start := time.Now()
+	_, err = cmd.RunExpect("sleep 5", []execmd.ExpectStep{{Pattern: "never", Timeout: 300 * time.Millisecond}})
+	if !errors.Is(err, execmd.ErrExpect) {
+		t.Errorf("Expected expect error, got: %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Command is not killed on expect timeout")
+	}
+
+	_, err = cmd.RunExpect("echo done", []execmd.ExpectStep{{Pattern: "never"}})
+	if !errors.Is(err, execmd.ErrExpect) {
+		t.Errorf("Expected expect error, got: %v", err)
+	}
+
+	if _, err := cmd.RunExpect("true", []execmd.ExpectStep{{Pattern: "("}}); err == nil {
+		t.Error("Expected error on invalid pattern, but got nil")
+	}
+}
+
+func TestClusterSSHCmd_RunExpect(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	res, err := cluster.RunExpect(installer, installerSteps)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+
+	for _, r := range res {
+		if !strings.HasSuffix(r.Transcript, "got y ********\n") {
+			t.Errorf("Unexpected transcript on host %s: %q", r.Host, r.Transcript)
+		}
+	}
+
+	srv := execmd.NewSSHCmd(dummyHost)
+	_, err = srv.RunExpect("sleep 5", []execmd.ExpectStep{{Pattern: "never", Timeout: 300 * time.Millisecond}})
+	if !errors.Is(err, execmd.ErrExpect) {
+		t.Errorf("Expected expect error, got: %v", err)
+	}
+}
+
+func TestClusterSSHCmd_RunExpectNotStarted(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+	cluster.StopOnError = true
+	// the first host fails to start, so the others are never started
+	cluster.Cmds[0].SSHCmd.KeyPath = "i-am-not-exist"
+
+	before := runtime.NumGoroutine()
+	res, err := cluster.RunExpect(installer, installerSteps)
+	if err == nil {
+		t.Fatal("Expected error, but got nil")
+	}
+	if len(res) != 1 {
+		t.Errorf("Unexpected results: %+v", res)
+	}
+
+	// the expecters of both hosts are stopped
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Leaked goroutines: %d, before the run: %d", n, before)
+	}
+}
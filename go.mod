@@ -2,4 +2,10 @@ module github.com/mikhae1/execmd
 
 go 1.16
 
-require github.com/fatih/color v1.15.0
+require (
+	github.com/creack/pty v1.1.18
+	github.com/fatih/color v1.15.0
+	golang.org/x/sys v0.6.0
+	golang.org/x/term v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
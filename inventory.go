@@ -0,0 +1,669 @@
+package execmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory host and group variables applied to SSHCmd, Ansible names are accepted as aliases.
+const (
+	VarHost     = "host"
+	VarUser     = "user"
+	VarPort     = "port"
+	VarKey      = "key"
+	VarJumpHost = "jump_host"
+	VarCwd      = "cwd"
+	// VarEnvPrefix prefixes environment variables, e.g. env.LANG=C
+	VarEnvPrefix = "env."
+)
+
+var inventoryVarAliases = map[string]string{
+	"ansible_host":                 VarHost,
+	"ansible_user":                 VarUser,
+	"ansible_port":                 VarPort,
+	"ansible_ssh_private_key_file": VarKey,
+	"jump":                         VarJumpHost,
+}
+
+// Ansible-style host ranges, e.g. web[01:10]
+var ansibleRange = regexp.MustCompile(`\[([0-9]+):([0-9]+)\]`)
+
+// Inventory is a set of hosts organized in groups, with per-host and per-group variables.
+// Every host belongs to the "all" group, hosts without a group belong to the "ungrouped" group.
+type Inventory struct {
+	Hosts  []*InventoryHost
+	Groups map[string]*InventoryGroup
+
+	hostsByName map[string]*InventoryHost
+}
+
+// InventoryHost is an inventory host with its own variables.
+type InventoryHost struct {
+	Name string
+	Vars map[string]string
+}
+
+// InventoryGroup is a named group of hosts and child groups with group variables.
+type InventoryGroup struct {
+	Name     string
+	Hosts    []string
+	Children []string
+	Vars     map[string]string
+}
+
+// NewInventory initializes an empty Inventory.
+func NewInventory() *Inventory {
+	inv := &Inventory{
+		Groups:      map[string]*InventoryGroup{},
+		hostsByName: map[string]*InventoryHost{},
+	}
+	inv.group("all")
+	return inv
+}
+
+// LoadInventory reads an inventory file, files with .yml, .yaml or .json extensions
+// are parsed as YAML, all others as Ansible-style INI.
+func LoadInventory(filePath string) (*Inventory, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yml", ".yaml", ".json":
+		return ParseInventoryYAML(data)
+	default:
+		return ParseInventoryINI(data)
+	}
+}
+
+// ParseInventoryINI parses Ansible-style INI inventory:
+//
+//	web01 user=admin
+//
+//	[web]
+//	web[02:10].example.com port=2222
+//
+//	[web:vars]
+//	env.LANG=C
+//
+//	[prod:children]
+//	web
+func ParseInventoryINI(data []byte) (*Inventory, error) {
+	inv := NewInventory()
+	group, kind := "ungrouped", "hosts"
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group, kind = line[1:len(line)-1], "hosts"
+			if i := strings.LastIndex(group, ":"); i >= 0 {
+				group, kind = group[:i], group[i+1:]
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("inventory line %d: unknown section type %q", lineNum, kind)
+			}
+			inv.group(group)
+			continue
+		}
+
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("inventory line %d: %w", lineNum, err)
+		}
+
+		switch kind {
+		case "hosts":
+			vars, err := parseINIVars(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("inventory line %d: %w", lineNum, err)
+			}
+			if err := inv.addHosts(fields[0], group, vars); err != nil {
+				return nil, fmt.Errorf("inventory line %d: %w", lineNum, err)
+			}
+		case "vars":
+			vars, err := parseINIVars([]string{line})
+			if err != nil {
+				return nil, fmt.Errorf("inventory line %d: %w", lineNum, err)
+			}
+			mergeVars(inv.group(group).Vars, vars)
+		case "children":
+			inv.addChild(group, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+
+	return inv, nil
+}
+
+// ParseInventoryYAML parses YAML inventory in the Ansible layout:
+//
+//	all:
+//	  vars:
+//	    user: deploy
+//	  children:
+//	    web:
+//	      hosts:
+//	        web[01:10]:
+//	        web11: {port: 2222, env: {LANG: C}}
+func ParseInventoryYAML(data []byte) (*Inventory, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %w", err)
+	}
+
+	inv := NewInventory()
+	if len(doc.Content) == 0 {
+		return inv, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("inventory line %d: groups mapping expected", root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if err := inv.parseYAMLGroup(root.Content[i].Value, root.Content[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return inv, nil
+}
+
+// parseYAMLGroup parses a group node with its hosts, vars and children.
+func (inv *Inventory) parseYAMLGroup(name string, node *yaml.Node) error {
+	inv.group(name)
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("inventory line %d: group %s must be a mapping", node.Line, name)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			return fmt.Errorf("inventory line %d: %s of group %s must be a mapping", value.Line, key.Value, name)
+		}
+
+		switch key.Value {
+		case "hosts":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				vars := map[string]string{}
+				if err := flattenYAMLVars(vars, "", value.Content[j+1]); err != nil {
+					return err
+				}
+				if err := inv.addHosts(value.Content[j].Value, name, vars); err != nil {
+					return fmt.Errorf("inventory line %d: %w", value.Content[j].Line, err)
+				}
+			}
+		case "vars":
+			if err := flattenYAMLVars(inv.group(name).Vars, "", value); err != nil {
+				return err
+			}
+		case "children":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				child := value.Content[j].Value
+				inv.addChild(name, child)
+				if err := inv.parseYAMLGroup(child, value.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("inventory line %d: unknown key %s in group %s", key.Line, key.Value, name)
+		}
+	}
+
+	return nil
+}
+
+// flattenYAMLVars stores YAML variables into vars, nested mappings are flattened with dots (env.LANG).
+func flattenYAMLVars(vars map[string]string, prefix string, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := flattenYAMLVars(vars, prefix+node.Content[i].Value+".", node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil
+		}
+		vars[strings.TrimSuffix(prefix, ".")] = node.Value
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return fmt.Errorf("inventory line %d: %w", node.Line, err)
+		}
+		vars[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(value)
+	}
+
+	return nil
+}
+
+// Select returns names of hosts matching the selection expression, in inventory order.
+// Expression terms are separated with ':' or ',' and could be host names, group names
+// or shell patterns (web*); terms prefixed with '&' intersect the selection and
+// terms prefixed with '!' exclude hosts, e.g. web:&prod:!web03. Host names with a port are single terms,
+// e.g. web:!web03:2222.
+func (inv *Inventory) Select(expr string) ([]string, error) {
+	selected := map[string]bool{}
+	var intersect, exclude [][]string
+
+	for _, term := range inv.splitTerms(expr) {
+		op := ""
+		if strings.HasPrefix(term, "&") || strings.HasPrefix(term, "!") {
+			op, term = term[:1], term[1:]
+		}
+
+		hosts, err := inv.matchTerm(term)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "&":
+			intersect = append(intersect, hosts)
+		case "!":
+			exclude = append(exclude, hosts)
+		default:
+			for _, host := range hosts {
+				selected[host] = true
+			}
+		}
+	}
+
+	for _, hosts := range intersect {
+		selected = filterHosts(selected, hosts, true)
+	}
+	for _, hosts := range exclude {
+		selected = filterHosts(selected, hosts, false)
+	}
+
+	var names []string
+	for _, host := range inv.Hosts {
+		if selected[host.Name] {
+			names = append(names, host.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// splitTerms splits the selection expression on ':' and ',', but keeps host names with a port,
+// e.g. web:!db01:2222 is web and !db01:2222 if db01:2222 is an inventory host.
+func (inv *Inventory) splitTerms(expr string) []string {
+	var terms []string
+	for _, chunk := range strings.Split(expr, ",") {
+		parts := strings.Split(chunk, ":")
+		for i := 0; i < len(parts); i++ {
+			term := parts[i]
+			for j := len(parts); j > i+1; j-- {
+				joined := strings.Join(parts[i:j], ":")
+				if _, ok := inv.hostsByName[strings.TrimLeft(strings.TrimSpace(joined), "&!")]; ok {
+					term, i = joined, j-1
+					break
+				}
+			}
+			if term = strings.TrimSpace(term); term != "" {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// HostVars returns merged variables of the host: "all" group variables first,
+// then variables of parent groups before their children, and host variables last.
+func (inv *Inventory) HostVars(name string) map[string]string {
+	return inv.hostVars(name, inv.hostGroups())
+}
+
+// hostVars returns merged variables of the host like .HostVars() with the groups of .hostGroups()
+func (inv *Inventory) hostVars(name string, hostGroups map[string][]string) map[string]string {
+	vars := map[string]string{}
+	host, ok := inv.hostsByName[name]
+	if !ok {
+		return vars
+	}
+
+	mergeVars(vars, inv.Groups["all"].Vars)
+	for _, groupName := range hostGroups[name] {
+		mergeVars(vars, inv.Groups[groupName].Vars)
+	}
+	mergeVars(vars, host.Vars)
+
+	return vars
+}
+
+// hostGroups returns the groups of every host except "all", parent groups before their children
+func (inv *Inventory) hostGroups() map[string][]string {
+	hostGroups := map[string][]string{}
+	for groupName := range inv.Groups {
+		if groupName == "all" {
+			continue
+		}
+		for _, host := range inv.GroupHosts(groupName) {
+			hostGroups[host] = append(hostGroups[host], groupName)
+		}
+	}
+
+	depth := inv.groupDepths()
+	for _, groups := range hostGroups {
+		sort.Slice(groups, func(i, j int) bool {
+			if depth[groups[i]] != depth[groups[j]] {
+				return depth[groups[i]] < depth[groups[j]]
+			}
+			return groups[i] < groups[j]
+		})
+	}
+
+	return hostGroups
+}
+
+// GroupHosts returns names of hosts of the group and all its child groups.
+func (inv *Inventory) GroupHosts(name string) []string {
+	if name == "all" {
+		names := make([]string, len(inv.Hosts))
+		for i, host := range inv.Hosts {
+			names[i] = host.Name
+		}
+		return names
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	visited := map[string]bool{}
+
+	var walk func(name string)
+	walk = func(name string) {
+		group, ok := inv.Groups[name]
+		if !ok || visited[name] {
+			return
+		}
+		visited[name] = true
+
+		for _, host := range group.Hosts {
+			if !seen[host] {
+				seen[host] = true
+				names = append(names, host)
+			}
+		}
+		for _, child := range group.Children {
+			walk(child)
+		}
+	}
+	walk(name)
+
+	return names
+}
+
+// NewClusterSSHCmd initializes ClusterSSHCmd for hosts matching the selection expression
+// (see Inventory.Select), every SSHCmd is configured from the host variables,
+// which are also available to command templates as .Vars.
+func (inv *Inventory) NewClusterSSHCmd(expr string) (*ClusterSSHCmd, error) {
+	hosts, err := inv.Select(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no inventory hosts match %q", expr)
+	}
+
+	c := NewClusterSSHCmd(nil)
+	c.Cmds = make([]ClusterCmd, len(hosts))
+	c.Errors = make([]error, len(hosts))
+	hostGroups := inv.hostGroups()
+	for i, host := range hosts {
+		c.Cmds[i].Host = host
+		c.Cmds[i].SSHCmd = *NewSSHCmd(host)
+		c.Cmds[i].Vars = inv.hostVars(host, hostGroups)
+		applyHostVars(&c.Cmds[i].SSHCmd, c.Cmds[i].Vars)
+	}
+
+	return c, nil
+}
+
+// applyHostVars configures SSHCmd with inventory variables.
+func applyHostVars(ssh *SSHCmd, vars map[string]string) {
+	for name, value := range vars {
+		if alias, ok := inventoryVarAliases[name]; ok {
+			name = alias
+		}
+
+		switch name {
+		case VarHost:
+			ssh.Host = value
+		case VarUser:
+			ssh.User = value
+		case VarPort:
+			ssh.Port = value
+		case VarKey:
+			ssh.KeyPath = value
+		case VarJumpHost:
+			ssh.JumpHost = value
+		case VarCwd:
+			ssh.Cwd = value
+		default:
+			if strings.HasPrefix(name, VarEnvPrefix) {
+				if ssh.Env == nil {
+					ssh.Env = map[string]string{}
+				}
+				ssh.Env[strings.TrimPrefix(name, VarEnvPrefix)] = value
+			}
+		}
+	}
+}
+
+// matchTerm returns hosts of a single selection term.
+func (inv *Inventory) matchTerm(term string) ([]string, error) {
+	if term == "all" || term == "*" {
+		return inv.GroupHosts("all"), nil
+	}
+	if _, ok := inv.Groups[term]; ok {
+		return inv.GroupHosts(term), nil
+	}
+	if _, ok := inv.hostsByName[term]; ok {
+		return []string{term}, nil
+	}
+
+	var hosts []string
+	if strings.ContainsAny(term, "*?[") {
+		for groupName := range inv.Groups {
+			if ok, _ := path.Match(term, groupName); ok {
+				hosts = append(hosts, inv.GroupHosts(groupName)...)
+			}
+		}
+		for _, host := range inv.Hosts {
+			if ok, _ := path.Match(term, host.Name); ok {
+				hosts = append(hosts, host.Name)
+			}
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no inventory hosts or groups match %q", term)
+	}
+
+	return hosts, nil
+}
+
+// addHosts adds hosts of a nodeset pattern to the group, Ansible ranges (web[01:10]) are supported.
+func (inv *Inventory) addHosts(pattern, groupName string, vars map[string]string) error {
+	names, err := ExpandHosts(ansibleRange.ReplaceAllString(pattern, "[$1-$2]"))
+	if err != nil {
+		return err
+	}
+
+	group := inv.group(groupName)
+	for _, name := range names {
+		host, ok := inv.hostsByName[name]
+		if !ok {
+			host = &InventoryHost{Name: name, Vars: map[string]string{}}
+			inv.hostsByName[name] = host
+			inv.Hosts = append(inv.Hosts, host)
+		}
+		mergeVars(host.Vars, vars)
+
+		if groupName != "all" && !containsString(group.Hosts, name) {
+			group.Hosts = append(group.Hosts, name)
+		}
+	}
+
+	return nil
+}
+
+// addChild adds the child group to the parent group.
+func (inv *Inventory) addChild(parent, child string) {
+	inv.group(child)
+	group := inv.group(parent)
+	if !containsString(group.Children, child) {
+		group.Children = append(group.Children, child)
+	}
+}
+
+// group returns the group by name, creating it if needed.
+func (inv *Inventory) group(name string) *InventoryGroup {
+	group, ok := inv.Groups[name]
+	if !ok {
+		group = &InventoryGroup{Name: name, Vars: map[string]string{}}
+		inv.Groups[name] = group
+	}
+	return group
+}
+
+// groupDepths returns the nesting level of each group: top level groups are 0, their children are 1, etc.
+func (inv *Inventory) groupDepths() map[string]int {
+	depth := map[string]int{}
+
+	var walk func(name string, level int)
+	walk = func(name string, level int) {
+		if d, ok := depth[name]; ok && d >= level {
+			return
+		}
+		// guard against cycles in children
+		if level > len(inv.Groups) {
+			return
+		}
+		depth[name] = level
+		for _, child := range inv.Groups[name].Children {
+			walk(child, level+1)
+		}
+	}
+
+	for name := range inv.Groups {
+		walk(name, 0)
+	}
+
+	return depth
+}
+
+// parseINIVars parses key=value pairs.
+func parseINIVars(fields []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, field := range fields {
+		i := strings.Index(field, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid variable %q, expected key=value", field)
+		}
+		vars[strings.TrimSpace(field[:i])] = unquote(strings.TrimSpace(field[i+1:]))
+	}
+	return vars, nil
+}
+
+// splitFields splits a line by spaces keeping quoted parts together, e.g. a=1 b="x y".
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			field.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			field.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote in %q", line)
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
+
+// unquote strips matching single or double quotes around the value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// filterHosts keeps hosts which are (keep=true) or are not (keep=false) in the list.
+func filterHosts(selected map[string]bool, hosts []string, keep bool) map[string]bool {
+	in := map[string]bool{}
+	for _, host := range hosts {
+		in[host] = true
+	}
+
+	res := map[string]bool{}
+	for host := range selected {
+		if in[host] == keep {
+			res[host] = true
+		}
+	}
+	return res
+}
+
+func mergeVars(dst, src map[string]string) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
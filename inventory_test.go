@@ -0,0 +1,182 @@
+package execmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+const iniInventory = `
+bastion ansible_host=10.0.0.1
+
+[web]
+web[01:04] port=2222
+web05 user=admin env.APP_ENV="prod web"
+
+[db]
+db1
+db2 cwd=/var/lib/db
+
+[web:vars]
+user=deploy
+jump_host=bastion
+
+[prod:children]
+web
+db
+
+[prod:vars]
+user=ops
+env.APP_ENV=prod
+`
+
+const yamlInventory = `
+all:
+  vars:
+    user: ops
+  hosts:
+    bastion:
+      ansible_host: 10.0.0.1
+  children:
+    prod:
+      vars:
+        env:
+          APP_ENV: prod
+      children:
+        web:
+          vars:
+            user: deploy
+            jump_host: bastion
+          hosts:
+            web[01:04]:
+              port: 2222
+            web05:
+              user: admin
+              env: {APP_ENV: prod web}
+        db:
+          hosts:
+            db1:
+            db2: {cwd: /var/lib/db}
+`
+
+func TestParseInventory(t *testing.T) {
+	iniInv, err := execmd.ParseInventoryINI([]byte(iniInventory))
+	if err != nil {
+		t.Fatalf("Failed to parse INI inventory: %v", err)
+	}
+	yamlInv, err := execmd.ParseInventoryYAML([]byte(yamlInventory))
+	if err != nil {
+		t.Fatalf("Failed to parse YAML inventory: %v", err)
+	}
+
+	for name, inv := range map[string]*execmd.Inventory{"ini": iniInv, "yaml": yamlInv} {
+		if len(inv.Hosts) != 8 {
+			t.Errorf("%s: expected 8 hosts, got %d", name, len(inv.Hosts))
+		}
+
+		expected := []string{"web01", "web02", "web03", "web04", "web05", "db1", "db2"}
+		if hosts := inv.GroupHosts("prod"); !reflect.DeepEqual(hosts, expected) {
+			t.Errorf("%s: unexpected prod hosts: %v", name, hosts)
+		}
+
+		vars := inv.HostVars("web02")
+		if vars["user"] != "deploy" || vars["port"] != "2222" || vars["env.APP_ENV"] != "prod" {
+			t.Errorf("%s: unexpected web02 vars: %v", name, vars)
+		}
+
+		vars = inv.HostVars("web05")
+		if vars["user"] != "admin" || vars["env.APP_ENV"] != "prod web" {
+			t.Errorf("%s: unexpected web05 vars: %v", name, vars)
+		}
+
+		vars = inv.HostVars("db2")
+		if vars["user"] != "ops" || vars["cwd"] != "/var/lib/db" {
+			t.Errorf("%s: unexpected db2 vars: %v", name, vars)
+		}
+	}
+}
+
+func TestInventory_Select(t *testing.T) {
+	inv, err := execmd.ParseInventoryINI([]byte(iniInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{"web:&prod:!web03", []string{"web01", "web02", "web04", "web05"}},
+		{"db,bastion", []string{"bastion", "db1", "db2"}},
+		{"all:!prod", []string{"bastion"}},
+		{"web0*:!web0[1-3]", []string{"web04", "web05"}},
+		{"prod:&db2", []string{"db2"}},
+	}
+
+	for _, tt := range tests {
+		hosts, err := inv.Select(tt.expr)
+		if err != nil {
+			t.Errorf("Select(%s): unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(hosts, tt.expected) {
+			t.Errorf("Select(%s) = %v, expected %v", tt.expr, hosts, tt.expected)
+		}
+	}
+
+	if _, err := inv.Select("web:!nope"); err == nil {
+		t.Error("Expected error for unknown host, but got nil")
+	}
+
+	// host names with a port are not split
+	inv, err = execmd.ParseInventoryINI([]byte("[web]\nweb01\nweb01:2222\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts, err := inv.Select("web:!web01:2222"); err != nil || !reflect.DeepEqual(hosts, []string{"web01"}) {
+		t.Errorf("Unexpected selection without the host with a port: %v, %v", hosts, err)
+	}
+	if hosts, err := inv.Select("web01:2222"); err != nil || !reflect.DeepEqual(hosts, []string{"web01:2222"}) {
+		t.Errorf("Unexpected selection of the host with a port: %v, %v", hosts, err)
+	}
+}
+
+func TestLoadInventory_NewClusterSSHCmd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.yml")
+	if err := os.WriteFile(path, []byte(yamlInventory), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := execmd.LoadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err := inv.NewClusterSSHCmd("web:!web02:!web03:!web04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cluster.Cmds) != 2 {
+		t.Fatalf("Expected 2 hosts, got %d", len(cluster.Cmds))
+	}
+
+	web01 := cluster.Cmds[0].SSHCmd
+	if cluster.Cmds[0].Host != "web01" || web01.User != "deploy" || web01.Port != "2222" || web01.JumpHost != "bastion" {
+		t.Errorf("Unexpected web01 settings: %+v", web01)
+	}
+	if web01.Env["APP_ENV"] != "prod" {
+		t.Errorf("Unexpected web01 env: %v", web01.Env)
+	}
+
+	web05 := cluster.Cmds[1].SSHCmd
+	if web05.User != "admin" || web05.Port != "" || web05.Env["APP_ENV"] != "prod web" {
+		t.Errorf("Unexpected web05 settings: %+v", web05)
+	}
+
+	if _, err := inv.NewClusterSSHCmd("web:&db"); err == nil {
+		t.Error("Expected error for empty selection, but got nil")
+	}
+}
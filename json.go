@@ -0,0 +1,135 @@
+package execmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Streams of output events printed with Cmd.JSONLines
+const (
+	StreamCmd    = "cmd"
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// OutputEvent is an output line printed as a JSON object with Cmd.JSONLines, e.g.
+// {"time":"2024-05-01T10:00:00.123Z","host":"web01","stream":"stdout","line":"up 3 days"}.
+// The command is printed as an event of the "cmd" stream, the host is empty for local commands.
+type OutputEvent struct {
+	Time   time.Time `json:"time"`
+	Host   string    `json:"host,omitempty"`
+	Stream string    `json:"stream"`
+	Line   string    `json:"line"`
+}
+
+// encodeEvent returns the JSON output event of the line without the line ending
+func encodeEvent(host, stream, line string) string {
+	data, _ := json.Marshal(OutputEvent{
+		Time:   time.Now(),
+		Host:   host,
+		Stream: stream,
+		Line:   strings.TrimRight(line, "\r\n"),
+	})
+	return string(data)
+}
+
+// ResultRecord is the JSON form of a command result, see NewResultRecord and ClusterRes.Record.
+type ResultRecord struct {
+	Host       string       `json:"host,omitempty"`
+	Status     ResultStatus `json:"status"`
+	ExitCode   int          `json:"exit_code"`
+	DurationMs int64        `json:"duration_ms"`
+	Stdout     string       `json:"stdout"`
+	Stderr     string       `json:"stderr"`
+	Error      string       `json:"error,omitempty"`
+	// ErrorKind classifies the error: the ssh failure kind for unreachable hosts, e.g. "connection refused",
+	// "cluster timeout", "stopped" or "canceled" for hosts of an interrupted cluster run,
+	// "timeout" for commands killed on timeout, "exit" for commands exited with an error
+	// and "signal" for otherwise killed commands
+	ErrorKind string `json:"error_kind,omitempty"`
+}
+
+// NewResultRecord returns the JSON form of a command result with its error.
+func NewResultRecord(res CmdRes, err error) ResultRecord {
+	rec := ResultRecord{
+		Status:     resultStatus(res, err, nil),
+		ExitCode:   exitCode(err),
+		DurationMs: res.Duration.Milliseconds(),
+		Stdout:     bufferString(res.Stdout),
+		Stderr:     bufferString(res.Stderr),
+		ErrorKind:  errorKind(err),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if rec.ErrorKind == "signal" && res.TimedOut {
+		rec.ErrorKind = "timeout"
+	}
+
+	return rec
+}
+
+// Record returns the JSON form of the host result.
+func (r ClusterRes) Record() ResultRecord {
+	rec := NewResultRecord(r.Res, r.Err)
+	rec.Host = r.Host
+	rec.Status = r.Status()
+	return rec
+}
+
+// WriteJSON writes the results as an indented JSON array of ResultRecord.
+func WriteJSON(w io.Writer, results []ClusterRes) error {
+	records := make([]ResultRecord, len(results))
+	for i, r := range results {
+		records[i] = r.Record()
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// WriteJSONLines writes the results as JSON Lines, one ResultRecord per line.
+func WriteJSONLines(w io.Writer, results []ClusterRes) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(r.Record()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// errorKind classifies the command error for ResultRecord
+func errorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	if sshErr := AsSSHError(err); sshErr != nil {
+		return string(sshErr.Kind)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, ErrClusterTimeout):
+		return "cluster timeout"
+	case errors.Is(err, ErrStopped):
+		return "stopped"
+	case errors.Is(err, ErrCanceled):
+		return "canceled"
+	case errors.Is(err, ErrExpect):
+		return "expect"
+	case errors.As(err, &exitErr) && exitErr.Exited():
+		return "exit"
+	case errors.As(err, &exitErr):
+		return "signal"
+	default:
+		return "error"
+	}
+}
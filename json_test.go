@@ -0,0 +1,165 @@
+package execmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+// captureOutput returns what fn prints to the process stdout and stderr
+func captureOutput(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+
+	capture := func(f **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		orig := *f
+		*f = w
+
+		var buf bytes.Buffer
+		done := make(chan struct{})
+		go func() {
+			io.Copy(&buf, r)
+			close(done)
+		}()
+
+		return func() string {
+			w.Close()
+			*f = orig
+			<-done
+			return buf.String()
+		}
+	}
+
+	stdout, stderr := capture(&os.Stdout), capture(&os.Stderr)
+	fn()
+	return stdout(), stderr()
+}
+
+func decodeEvents(t *testing.T, output string) []execmd.OutputEvent {
+	t.Helper()
+
+	var events []execmd.OutputEvent
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var event execmd.OutputEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid JSON event %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestCmd_JSONLines(t *testing.T) {
+	cmd := execmd.NewCmd()
+	cmd.JSONLines = true
+
+	var res execmd.CmdRes
+	stdout, stderr := captureOutput(t, func() {
+		var err error
+		if res, err = cmd.Run("echo out; echo err >&2; printf partial"); err != nil {
+			t.Errorf("Failed to run command: %v", err)
+		}
+	})
+
+	events := decodeEvents(t, stdout)
+	if len(events) != 3 {
+		t.Fatalf("Unexpected events: %s", stdout)
+	}
+	for i, expected := range []execmd.OutputEvent{
+		{Stream: execmd.StreamCmd, Line: "echo out; echo err >&2; printf partial"},
+		{Stream: execmd.StreamStdout, Line: "out"},
+		{Stream: execmd.StreamStdout, Line: "partial"},
+	} {
+		if events[i].Stream != expected.Stream || events[i].Line != expected.Line || events[i].Host != "" {
+			t.Errorf("Unexpected event %d: %+v", i, events[i])
+		}
+		if events[i].Time.IsZero() {
+			t.Errorf("Event %d has no time", i)
+		}
+	}
+
+	if events := decodeEvents(t, stderr); len(events) != 1 || events[0].Stream != execmd.StreamStderr || events[0].Line != "err" {
+		t.Errorf("Unexpected stderr events: %s", stderr)
+	}
+
+	// the incomplete last line is recorded too
+	if res.Stdout.String() != "out\npartial" {
+		t.Errorf("Unexpected stdout: %q", res.Stdout.String())
+	}
+
+	srv := execmd.NewSSHCmd(dummyHost)
+	srv.Cmd.JSONLines = true
+	stdout, _ = captureOutput(t, func() {
+		srv.Run("echo remote")
+	})
+	events = decodeEvents(t, stdout)
+	if len(events) != 2 || events[1].Host != dummyHost || events[1].Line != "remote" {
+		t.Errorf("Unexpected remote events: %s", stdout)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd([]string{dummyHost, "127.0.0.1:1"})
+	results, _ := cluster.Run("echo hi; exit 3")
+
+	var buf bytes.Buffer
+	if err := execmd.WriteJSON(&buf, results); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+
+	var records []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", buf.String(), err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Unexpected records: %s", buf.String())
+	}
+
+	failed, unreachable := records[0], records[1]
+	if failed["host"] != dummyHost || failed["status"] != "failed" || failed["exit_code"] != 3.0 ||
+		failed["stdout"] != "hi\n" || failed["error_kind"] != "exit" {
+		t.Errorf("Unexpected failed record: %v", failed)
+	}
+	if _, ok := failed["duration_ms"]; !ok {
+		t.Errorf("No duration in record: %v", failed)
+	}
+	if unreachable["status"] != "unreachable" || unreachable["exit_code"] != 255.0 || unreachable["error_kind"] == "" {
+		t.Errorf("Unexpected unreachable record: %v", unreachable)
+	}
+
+	buf.Reset()
+	if err := execmd.WriteJSONLines(&buf, results); err != nil {
+		t.Fatalf("Failed to write JSON Lines: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"host":"127.0.0.1:1"`) {
+		t.Errorf("Unexpected JSON Lines: %s", buf.String())
+	}
+}
+
+func TestNewResultRecord(t *testing.T) {
+	cmd := execmd.NewCmd()
+	cmd.MuteCmd, cmd.MuteStdout, cmd.MuteStderr = true, true, true
+
+	res, err := cmd.Run("echo ok")
+	rec := execmd.NewResultRecord(res, err)
+	if rec.Status != execmd.StatusOK || rec.ExitCode != 0 || rec.Stdout != "ok\n" || rec.Error != "" || rec.ErrorKind != "" {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+
+	res, err = cmd.Run("sleep 5", 100*time.Millisecond)
+	rec = execmd.NewResultRecord(res, err)
+	if rec.Status != execmd.StatusTimeout || rec.ErrorKind != "timeout" || rec.Error == "" {
+		t.Errorf("Unexpected record of killed command: %+v", rec)
+	}
+}
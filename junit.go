@@ -0,0 +1,102 @@
+package execmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// JUnitSuite is a JUnit XML test suite of a cluster run with a test case per host, see NewJUnitSuite.
+type JUnitSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []JUnitTestCase `xml:"testcase"`
+}
+
+// JUnitTestCase is the result of a host: failed and timed out commands have a failure,
+// unreachable hosts have an error, and hosts not started are skipped.
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+	Error     *JUnitFailure `xml:"error,omitempty"`
+	Skipped   *JUnitFailure `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+// JUnitFailure describes a failed host, the text is the stderr of the command.
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// NewJUnitSuite returns the JUnit test suite of the cluster results, the name is usually the check command.
+// The time of the suite is the total time of the hosts.
+func NewJUnitSuite(name string, results []ClusterRes) JUnitSuite {
+	suite := JUnitSuite{Name: name, Tests: len(results)}
+
+	var total time.Duration
+	for _, r := range results {
+		tc := JUnitTestCase{
+			Name:      r.Host,
+			ClassName: name,
+			Time:      junitTime(r.Res.Duration),
+			SystemOut: bufferString(r.Res.Stdout),
+			SystemErr: bufferString(r.Res.Stderr),
+		}
+		total += r.Res.Duration
+
+		status := r.Status()
+		failure := &JUnitFailure{Type: string(status), Text: tc.SystemErr}
+		if r.Err != nil {
+			failure.Message = fmt.Sprintf("exit code %d: %v", exitCode(r.Err), r.Err)
+		}
+
+		switch status {
+		case StatusFailed, StatusTimeout:
+			tc.Failure = failure
+			suite.Failures++
+		case StatusUnreachable:
+			tc.Error = failure
+			suite.Errors++
+		case StatusSkipped:
+			tc.Skipped = &JUnitFailure{Message: r.Err.Error()}
+			suite.Skipped++
+		}
+
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = junitTime(total)
+
+	return suite
+}
+
+// WriteJUnit writes the cluster results as a JUnit XML report with a test case per host, see NewJUnitSuite.
+func WriteJUnit(w io.Writer, name string, results []ClusterRes) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(NewJUnitSuite(name, results)); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// junitTime formats the duration in seconds
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
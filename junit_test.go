@@ -0,0 +1,61 @@
+package execmd_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	results := clusterResults()
+
+	var buf bytes.Buffer
+	if err := execmd.WriteJUnit(&buf, "health check", results); err != nil {
+		t.Fatalf("Failed to write JUnit report: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Errorf("No XML header: %s", buf.String())
+	}
+
+	var suite execmd.JUnitSuite
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatalf("Invalid XML %s: %v", buf.String(), err)
+	}
+	if suite.Name != "health check" || suite.Tests != 4 || suite.Failures != 2 || suite.Errors != 1 || suite.Skipped != 0 {
+		t.Errorf("Unexpected suite: %+v", suite)
+	}
+
+	ok, failed, unreachable, timeout := suite.Cases[0], suite.Cases[1], suite.Cases[2], suite.Cases[3]
+	if ok.Name != "host-01" || ok.ClassName != "health check" || ok.Failure != nil || ok.Error != nil || ok.SystemOut != "ok\n" {
+		t.Errorf("Unexpected test case: %+v", ok)
+	}
+	if failed.Failure == nil || failed.Failure.Type != "failed" || failed.Failure.Text != "check <failed>\nlast | line\n" ||
+		!strings.HasPrefix(failed.Failure.Message, "exit code 3:") {
+		t.Errorf("Unexpected failed test case: %+v", failed)
+	}
+	if unreachable.Name != "host-03" || unreachable.Error == nil || unreachable.Failure != nil ||
+		!strings.HasPrefix(unreachable.Error.Message, "exit code 255:") {
+		t.Errorf("Unexpected unreachable test case: %+v", unreachable)
+	}
+	if timeout.Failure == nil || timeout.Failure.Type != "timeout" || timeout.Time != "1.000" {
+		t.Errorf("Unexpected timed out test case: %+v", timeout)
+	}
+}
+
+func TestNewJUnitSuite_Skipped(t *testing.T) {
+	results := []execmd.ClusterRes{
+		newClusterRes("host-01", "", "", errors.New("signal: killed")),
+		newClusterRes("host-02", "", "", execmd.ErrClusterTimeout),
+	}
+	results[0].Res.TimedOut = true
+	results[1].Skipped = true
+
+	suite := execmd.NewJUnitSuite("sleep", results)
+	if suite.Failures != 1 || suite.Skipped != 1 || suite.Cases[1].Skipped == nil || suite.Cases[1].Failure != nil {
+		t.Errorf("Unexpected suite: %+v", suite)
+	}
+}
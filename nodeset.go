@@ -0,0 +1,411 @@
+package execmd
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var digitRuns = regexp.MustCompile(`[0-9]+`)
+
+// hostIndex is a host name split around one of its numeric parts, e.g. web07.dc1 -> web, 07, .dc1
+type hostIndex struct {
+	prefix string
+	num    string
+	suffix string
+}
+
+// FoldHosts folds a list of host names into a compact nodeset, e.g.
+// web01, web02, web03, web05 -> web[01-03,05].
+// Hosts without numbers are kept as is, duplicates are removed.
+func FoldHosts(hosts []string) string {
+	var order []string
+	groups := map[string][]hostIndex{}
+
+	for _, idx := range splitHostIndexes(hosts) {
+		key := idx.prefix + "\x00" + idx.suffix
+		if idx.num == "" {
+			key = idx.prefix
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], idx)
+	}
+
+	folded := make([]string, 0, len(order))
+	for _, key := range order {
+		folded = append(folded, foldGroup(groups[key]))
+	}
+
+	return strings.Join(folded, ",")
+}
+
+// splitHostIndexes splits unique hosts around the numeric part to fold on.
+// Hosts of the same shape (web1.dc1, web2.dc1) are split on the last numeric part
+// that differs between them, so the constant ones stay in prefix or suffix.
+func splitHostIndexes(hosts []string) []hostIndex {
+	var unique []string
+	runs := map[string][][]int{}
+	shapes := map[string][]string{}
+	for _, host := range hosts {
+		if _, ok := runs[host]; ok {
+			continue
+		}
+		unique = append(unique, host)
+		runs[host] = digitRuns.FindAllStringIndex(host, -1)
+
+		shape := digitRuns.ReplaceAllString(host, "0")
+		shapes[shape] = append(shapes[shape], host)
+	}
+
+	// the numeric part position to fold on for each shape
+	foldPos := map[string]int{}
+	for shape, members := range shapes {
+		n := len(runs[members[0]])
+		pos := n - 1
+		for p := n - 1; p >= 0; p-- {
+			if runVaries(members, runs, p) {
+				pos = p
+				break
+			}
+		}
+		foldPos[shape] = pos
+	}
+
+	indexes := make([]hostIndex, 0, len(unique))
+	for _, host := range unique {
+		pos := foldPos[digitRuns.ReplaceAllString(host, "0")]
+		if pos < 0 {
+			indexes = append(indexes, hostIndex{prefix: host})
+			continue
+		}
+
+		run := runs[host][pos]
+		indexes = append(indexes, hostIndex{
+			prefix: host[:run[0]],
+			num:    host[run[0]:run[1]],
+			suffix: host[run[1]:],
+		})
+	}
+
+	return indexes
+}
+
+// runVaries reports whether numeric part at position pos differs between hosts.
+func runVaries(hosts []string, runs map[string][][]int, pos int) bool {
+	first := runs[hosts[0]][pos]
+	for _, host := range hosts[1:] {
+		run := runs[host][pos]
+		if host[run[0]:run[1]] != hosts[0][first[0]:first[1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// foldGroup folds hosts sharing the same prefix and suffix into a bracketed range list.
+func foldGroup(group []hostIndex) string {
+	first := group[0]
+	if len(group) == 1 {
+		return first.prefix + first.num + first.suffix
+	}
+
+	sort.SliceStable(group, func(i, j int) bool {
+		a, _ := strconv.ParseUint(group[i].num, 10, 64)
+		b, _ := strconv.ParseUint(group[j].num, 10, 64)
+		if a != b {
+			return a < b
+		}
+		return len(group[i].num) < len(group[j].num)
+	})
+
+	var ranges []string
+	start, prev := group[0].num, group[0].num
+	for _, idx := range group[1:] {
+		if !isNextIndex(prev, idx.num) {
+			ranges = append(ranges, formatRange(start, prev))
+			start = idx.num
+		}
+		prev = idx.num
+	}
+	ranges = append(ranges, formatRange(start, prev))
+
+	return first.prefix + "[" + strings.Join(ranges, ",") + "]" + first.suffix
+}
+
+// isNextIndex reports whether next directly follows prev with compatible zero padding.
+func isNextIndex(prev, next string) bool {
+	a, errA := strconv.ParseUint(prev, 10, 64)
+	b, errB := strconv.ParseUint(next, 10, 64)
+	if errA != nil || errB != nil || b != a+1 {
+		return false
+	}
+
+	return len(prev) == len(next) || (!isPadded(prev) && !isPadded(next))
+}
+
+// isPadded reports whether a numeric index has leading zeros.
+func isPadded(num string) bool {
+	return len(num) > 1 && num[0] == '0'
+}
+
+func formatRange(start, end string) string {
+	if start == end {
+		return start
+	}
+	return start + "-" + end
+}
+
+// MaxExpandedHosts limits the number of hosts a nodeset pattern expands to, e.g. a typo in web[0-99999999]
+const MaxExpandedHosts = 100000
+
+var rangeList = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+
+// ExpandHosts expands a nodeset pattern into a list of host names.
+// It supports numeric ranges with zero padding and comma lists in brackets (web[01-03,07]),
+// several brackets in one name (rack[1-2]-node[01-04]), comma separated patterns,
+// exclusions (web[01-40]!web07) and intersections (web[01-10]&web[05-20]).
+// Operators are applied from left to right, brackets holding an IP address (e.g. IPv6 addresses
+// as in root@[::1]:22) are kept as is, other brackets which don't hold a numeric range fail.
+// Patterns expanding to more than MaxExpandedHosts hosts fail.
+func ExpandHosts(pattern string) ([]string, error) {
+	var hosts []string
+	op := ','
+	start, depth := 0, 0
+
+	for i := 0; i <= len(pattern); i++ {
+		if i < len(pattern) {
+			switch pattern[i] {
+			case '[':
+				depth++
+				continue
+			case ']':
+				if depth == 0 {
+					return nil, fmt.Errorf("unexpected ']' at position %d in %q", i, pattern)
+				}
+				depth--
+				continue
+			case ',', '!', '&':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if depth > 0 {
+			return nil, fmt.Errorf("unclosed '[' in %q", pattern)
+		}
+
+		term := strings.TrimSpace(pattern[start:i])
+		if term == "" {
+			return nil, fmt.Errorf("empty host name at position %d in %q", start, pattern)
+		}
+
+		expanded, err := expandTerm(term)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand %q: %w", pattern, err)
+		}
+		hosts = applyHostsOp(hosts, expanded, op)
+
+		if i < len(pattern) {
+			op = rune(pattern[i])
+		}
+		start = i + 1
+	}
+
+	return hosts, nil
+}
+
+// expandTerm expands all the bracketed ranges of a single host pattern.
+func expandTerm(term string) ([]string, error) {
+	open := -1
+	for i := 0; i < len(term); i++ {
+		if term[i] != '[' {
+			continue
+		}
+
+		end := strings.IndexByte(term[i:], ']')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed '[' in %q", term)
+		}
+
+		inner := term[i+1 : i+end]
+		if rangeList.MatchString(inner) {
+			open = i
+			break
+		}
+		if !isBracketedIP(inner) {
+			return nil, fmt.Errorf("invalid range [%s] in %q", inner, term)
+		}
+		i += end
+	}
+
+	if open < 0 {
+		return []string{term}, nil
+	}
+
+	end := open + strings.IndexByte(term[open:], ']')
+	indexes, err := expandRangeList(term[open+1 : end])
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, idx := range indexes {
+		expanded, err := expandTerm(term[:open] + idx + term[end+1:])
+		if err != nil {
+			return nil, err
+		}
+		if len(hosts)+len(expanded) > MaxExpandedHosts {
+			return nil, fmt.Errorf("%q expands to more than %d hosts", term, MaxExpandedHosts)
+		}
+		hosts = append(hosts, expanded...)
+	}
+
+	return hosts, nil
+}
+
+// isBracketedIP reports whether the brackets hold an IP address, an IPv6 address may have a zone, e.g. fe80::1%eth0
+func isBracketedIP(inner string) bool {
+	if i := strings.IndexByte(inner, '%'); i >= 0 {
+		inner = inner[:i]
+	}
+	return net.ParseIP(inner) != nil
+}
+
+// expandRangeList expands a list like 01-03,07 into 01, 02, 03, 07 keeping zero padding.
+func expandRangeList(list string) ([]string, error) {
+	var indexes []string
+	for _, item := range strings.Split(list, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		if len(bounds) == 1 {
+			indexes = append(indexes, item)
+			continue
+		}
+
+		from, err := strconv.ParseUint(bounds[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", item, err)
+		}
+		to, err := strconv.ParseUint(bounds[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", item, err)
+		}
+		if from > to {
+			return nil, fmt.Errorf("invalid range %q: start is greater than end", item)
+		}
+		// to-from doesn't overflow unlike the count of indexes
+		if to-from >= uint64(MaxExpandedHosts-len(indexes)) {
+			return nil, fmt.Errorf("invalid range %q: more than %d hosts", item, MaxExpandedHosts)
+		}
+
+		width := 0
+		if isPadded(bounds[0]) {
+			width = len(bounds[0])
+		}
+		for n := from; ; n++ {
+			indexes = append(indexes, fmt.Sprintf("%0*d", width, n))
+			if n == to {
+				break
+			}
+		}
+	}
+
+	return indexes, nil
+}
+
+// applyHostsOp combines two host lists with a nodeset operator, keeping the order and removing duplicates.
+func applyHostsOp(hosts, other []string, op rune) []string {
+	inOther := map[string]bool{}
+	for _, host := range other {
+		inOther[host] = true
+	}
+
+	var res []string
+	seen := map[string]bool{}
+	add := func(host string) {
+		if !seen[host] {
+			seen[host] = true
+			res = append(res, host)
+		}
+	}
+
+	for _, host := range hosts {
+		switch op {
+		case '!':
+			if !inOther[host] {
+				add(host)
+			}
+		case '&':
+			if inOther[host] {
+				add(host)
+			}
+		default:
+			add(host)
+		}
+	}
+
+	if op == ',' {
+		for _, host := range other {
+			add(host)
+		}
+	}
+
+	return res
+}
+
+// expandHostList expands every nodeset pattern in the list, it fails on the first invalid pattern.
+func expandHostList(patterns []string) ([]string, error) {
+	var hosts []string
+	for _, pattern := range patterns {
+		expanded, err := ExpandHosts(pattern)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+
+	return hosts, nil
+}
+
+// splitHostAddr splits [user@]host[:port] into its parts,
+// IPv6 addresses can be given bare (::1) or in brackets ([::1]:22).
+func splitHostAddr(addr string) (user, host, port string) {
+	host = addr
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		user, host = host[:i], host[i+1:]
+	}
+
+	if strings.HasPrefix(host, "[") {
+		if end := strings.IndexByte(host, ']'); end > 0 {
+			rest := host[end+1:]
+			host = host[1:end]
+			if strings.HasPrefix(rest, ":") && isPort(rest[1:]) {
+				port = rest[1:]
+			}
+		}
+		return
+	}
+
+	// more than one colon is a bare IPv6 address
+	if strings.Count(host, ":") == 1 {
+		i := strings.IndexByte(host, ':')
+		if isPort(host[i+1:]) {
+			host, port = host[:i], host[i+1:]
+		}
+	}
+
+	return
+}
+
+func isPort(s string) bool {
+	if _, err := strconv.ParseUint(s, 10, 16); err != nil {
+		return false
+	}
+	return true
+}
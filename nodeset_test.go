@@ -0,0 +1,47 @@
+package execmd_test
+
+import (
+	"reflect"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestExpandHosts(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"web01", []string{"web01"}},
+		{"web[01-03]", []string{"web01", "web02", "web03"}},
+		{"web[8-10].dc1", []string{"web8.dc1", "web9.dc1", "web10.dc1"}},
+		{"web[01-02,05]", []string{"web01", "web02", "web05"}},
+		{"rack[1-2]-node[1-2]", []string{"rack1-node1", "rack1-node2", "rack2-node1", "rack2-node2"}},
+		{"web[01-02],db1", []string{"web01", "web02", "db1"}},
+		{"web[01-04]!web03", []string{"web01", "web02", "web04"}},
+		{"web[01-04]&web[03-08]", []string{"web03", "web04"}},
+		{"root@web[1-2]:2222", []string{"root@web1:2222", "root@web2:2222"}},
+		{"root@[::1]:22", []string{"root@[::1]:22"}},
+		{"[fe80::1%eth0]", []string{"[fe80::1%eth0]"}},
+		{"web1,web1", []string{"web1"}},
+	}
+
+	for _, tt := range tests {
+		hosts, err := execmd.ExpandHosts(tt.pattern)
+		if err != nil {
+			t.Errorf("ExpandHosts(%s): unexpected error: %v", tt.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(hosts, tt.expected) {
+			t.Errorf("ExpandHosts(%s) = %v, expected %v", tt.pattern, hosts, tt.expected)
+		}
+	}
+
+	for _, pattern := range []string{"web[01-03", "web01]", "web[5-1]", "web[01-03],,db1",
+		"web[0-18446744073709551615]", "web[0-99999999999]", "rack[1-1000]-node[1-1000]",
+		"web[a-b]", "web[1-]"} {
+		if _, err := execmd.ExpandHosts(pattern); err == nil {
+			t.Errorf("ExpandHosts(%s): expected error, but got nil", pattern)
+		}
+	}
+}
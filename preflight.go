@@ -0,0 +1,207 @@
+package execmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultPreflightTimeout limits every host check if PreflightOptions.Timeout is not set
+const DefaultPreflightTimeout = 5 * time.Second
+
+// ErrPreflight is returned if some hosts failed the preflight check
+var ErrPreflight = errors.New("preflight failed")
+
+// HostStatus is the preflight status of a cluster host.
+type HostStatus string
+
+// Preflight statuses, every status except HostOK is a failure.
+const (
+	HostOK          HostStatus = "ok"
+	HostUnreachable HostStatus = "unreachable"
+	HostAuthFailed  HostStatus = "auth failed"
+	HostTimeout     HostStatus = "timeout"
+	HostNoSudo      HostStatus = "no passwordless sudo"
+)
+
+// PreflightOptions customize .Preflight() checks.
+type PreflightOptions struct {
+	// Timeout limits every host check, DefaultPreflightTimeout if zero
+	Timeout time.Duration
+	// Sudo checks that sudo works without a password
+	Sudo bool
+	// DropFailed removes failed hosts from the cluster instead of returning ErrPreflight,
+	// the error is still returned if no hosts are left.
+	DropFailed bool
+}
+
+// PreflightRes is the preflight check result of a single host.
+type PreflightRes struct {
+	Host    string
+	Status  HostStatus
+	Err     error
+	Latency time.Duration
+}
+
+// PreflightReport holds preflight results in the order of cluster hosts.
+type PreflightReport []PreflightRes
+
+// Failed returns hosts which failed the check.
+func (r PreflightReport) Failed() []string {
+	var hosts []string
+	for _, res := range r {
+		if res.Status != HostOK {
+			hosts = append(hosts, res.Host)
+		}
+	}
+	return hosts
+}
+
+// WriteTable renders the report as a table with a row per host.
+func (r PreflightReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSTATUS\tLATENCY\tERROR")
+	for _, res := range r {
+		errMsg := ""
+		if res.Err != nil {
+			errMsg = res.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Host, res.Status, res.Latency.Round(time.Millisecond), errMsg)
+	}
+
+	return tw.Flush()
+}
+
+// Ping checks in parallel that every host is reachable and accepts the authentication,
+// see .Preflight().
+func (c *ClusterSSHCmd) Ping(timeout ...time.Duration) (PreflightReport, error) {
+	opts := PreflightOptions{}
+	if len(timeout) > 0 {
+		opts.Timeout = timeout[0]
+	}
+
+	return c.Preflight(opts)
+}
+
+// Preflight checks in parallel that every host is reachable, accepts the authentication in batch mode
+// (without password prompts) and optionally runs sudo without a password.
+// It returns the report with a status per host, and ErrPreflight if any host failed,
+// unless failed hosts are dropped with opts.DropFailed.
+// Preflight must not be called concurrently with other cluster runs if opts.DropFailed is true.
+func (c *ClusterSSHCmd) Preflight(opts PreflightOptions) (PreflightReport, error) {
+	if c.hostsErr != nil {
+		return nil, c.hostsErr
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultPreflightTimeout
+	}
+
+	report := make(PreflightReport, len(c.Cmds))
+	var wg sync.WaitGroup
+	for i := range c.Cmds {
+		wg.Add(1)
+		go func(i int, cmd ClusterCmd) {
+			defer wg.Done()
+			report[i] = cmd.SSHCmd.preflight(opts.Sudo, opts.Timeout)
+			report[i].Host = cmd.Host
+		}(i, c.Cmds[i])
+	}
+	wg.Wait()
+
+	failed := report.Failed()
+	if len(failed) == 0 {
+		return report, nil
+	}
+
+	err := fmt.Errorf("%w on %d of %d hosts: %s", ErrPreflight, len(failed), len(report), FoldHosts(failed))
+	if opts.DropFailed {
+		c.DropHosts(failed...)
+		if len(c.Cmds) > 0 {
+			return report, nil
+		}
+	}
+
+	return report, err
+}
+
+// DropHosts removes hosts from the cluster, e.g. the failed hosts of the preflight report.
+// It must not be called concurrently with cluster runs.
+func (c *ClusterSSHCmd) DropHosts(hosts ...string) {
+	drop := map[string]bool{}
+	for _, host := range hosts {
+		drop[host] = true
+	}
+
+	var cmds []ClusterCmd
+	for _, cmd := range c.Cmds {
+		if !drop[cmd.Host] {
+			cmds = append(cmds, cmd)
+		}
+	}
+
+	c.Cmds = cmds
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Errors = make([]error, len(cmds))
+}
+
+// preflight runs the check command in ssh batch mode with muted output and classifies the result.
+func (s *SSHCmd) preflight(sudo bool, timeout time.Duration) PreflightRes {
+	command := "true"
+	if sudo {
+		command = "sudo -n true"
+	}
+
+	probe := s.muted()
+	probe.Options = append(append([]string{}, s.Options...),
+		"BatchMode=yes",
+		fmt.Sprintf("ConnectTimeout=%d", int(math.Ceil(timeout.Seconds()))),
+	)
+
+	// the check command runs as is, without the working dir, environment or a forced pseudo-terminal
+	sshArgs, err := probe.sshCommand(command, false)
+	if err != nil {
+		return PreflightRes{Status: HostUnreachable, Err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	opts := probe.startOptions()
+	opts.mapErr = probe.classifySSHError
+	proc, err := probe.Cmd.start(ctx, strings.Join(sshArgs, " "), opts)
+	if err == nil {
+		err = proc.Wait()
+	}
+
+	res := PreflightRes{Err: err, Latency: proc.Res.Duration}
+	switch sshErr := AsSSHError(err); {
+	case err == nil:
+		res.Status = HostOK
+	case ctx.Err() == context.DeadlineExceeded:
+		res.Status = HostTimeout
+	case sshErr != nil && sshErr.Kind == SSHErrTimeout:
+		res.Status = HostTimeout
+	case sshErr != nil && (sshErr.Kind == SSHErrAuth || sshErr.Kind == SSHErrHostKey):
+		res.Status = HostAuthFailed
+	case sshErr != nil:
+		res.Status = HostUnreachable
+	case !sudo:
+		res.Status = HostUnreachable
+	default:
+		// ssh succeeded, so sudo failed
+		res.Status = HostNoSudo
+		if stderr := strings.TrimSpace(bufferString(proc.Res.Stderr)); stderr != "" {
+			res.Err = fmt.Errorf("%s: %w", stderr, err)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,80 @@
+package execmd_test
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestClusterSSHCmd_Ping(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(append(dummyHosts, "unreachable-host"))
+
+	report, err := cluster.Ping(2 * time.Second)
+	if !errors.Is(err, execmd.ErrPreflight) {
+		t.Errorf("Expected preflight error, got: %v", err)
+	}
+	if len(report) != len(dummyHosts)+1 {
+		t.Fatalf("Unexpected number of results: %d", len(report))
+	}
+
+	for _, res := range report[:len(dummyHosts)] {
+		if res.Status != execmd.HostOK {
+			t.Errorf("Unexpected status on host %s: %s (%v)", res.Host, res.Status, res.Err)
+		}
+	}
+	if res := report[len(dummyHosts)]; res.Status != execmd.HostUnreachable || execmd.AsSSHError(res.Err) == nil {
+		t.Errorf("Unexpected status on host %s: %s (%v)", res.Host, res.Status, res.Err)
+	}
+
+	var table bytes.Buffer
+	if err := report.WriteTable(&table); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+	if !strings.Contains(table.String(), "unreachable-host  unreachable") {
+		t.Errorf("Unexpected table:\n%s", table.String())
+	}
+}
+
+func TestClusterSSHCmd_PreflightDropFailed(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(append(dummyHosts, "unreachable-host"))
+
+	report, err := cluster.Preflight(execmd.PreflightOptions{Timeout: 2 * time.Second, DropFailed: true})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if failed := report.Failed(); len(failed) != 1 || failed[0] != "unreachable-host" {
+		t.Errorf("Unexpected failed hosts: %v", failed)
+	}
+	if len(cluster.Cmds) != len(dummyHosts) {
+		t.Fatalf("Failed hosts are not dropped: %d hosts left", len(cluster.Cmds))
+	}
+
+	if _, err := cluster.Run("true"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	cluster = execmd.NewClusterSSHCmd([]string{"unreachable-host"})
+	if _, err := cluster.Preflight(execmd.PreflightOptions{DropFailed: true}); !errors.Is(err, execmd.ErrPreflight) {
+		t.Errorf("Expected preflight error with no hosts left, got: %v", err)
+	}
+}
+
+func TestClusterSSHCmd_PreflightSudo(t *testing.T) {
+	expected := execmd.HostOK
+	if exec.Command("sh", "-c", "sudo -n true").Run() != nil {
+		expected = execmd.HostNoSudo
+	}
+
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+	report, _ := cluster.Preflight(execmd.PreflightOptions{Sudo: true})
+	for _, res := range report {
+		if res.Status != expected {
+			t.Errorf("Unexpected status on host %s: %s (%v)", res.Host, res.Status, res.Err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package execmd
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is not supported on windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command process only
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
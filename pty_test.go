@@ -0,0 +1,137 @@
+//go:build !windows
+// +build !windows
+
+package execmd_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestCmd_PTY(t *testing.T) {
+	cmd := execmd.NewCmd()
+	cmd.PTY = true
+
+	res, err := cmd.Run("[ -t 0 ] && [ -t 1 ] && [ -t 2 ] && echo tty; stty size; echo Hello stderr >&2")
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+
+	stdout := res.Stdout.String()
+	if !strings.Contains(stdout, "tty\r\n") {
+		t.Errorf("Expected a terminal, got: %q", stdout)
+	}
+	if !strings.Contains(stdout, "24 80\r\n") {
+		t.Errorf("Expected the default window size, got: %q", stdout)
+	}
+	if !strings.Contains(stdout, "Hello stderr\r\n") || res.Stderr.Len() != 0 {
+		t.Errorf("Expected stderr merged into stdout, got: %q, %q", stdout, res.Stderr.String())
+	}
+
+	res, err = cmd.Run("exit 3")
+	if err == nil || res.ExitCode != 3 {
+		t.Errorf("Unexpected result: %d, %v", res.ExitCode, err)
+	}
+
+	start := time.Now()
+	_, err = cmd.Run("sleep 5", 200*time.Millisecond)
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Errorf("Expected timeout kill in time, got: %v after %s", err, time.Since(start))
+	}
+}
+
+func TestCmd_PTYBecomeSu(t *testing.T) {
+	// su which reads the password from the terminal only
+	dir := t.TempDir()
+	script := `#!/bin/sh
+[ -t 0 ] || { echo "su: must be run from a terminal" >&2; exit 1; }
+printf 'Password: '
+IFS= read -r pw
+[ "$pw" = "secret" ] || { echo "su: Authentication failure" >&2; exit 1; }
+exec sh -c "$3"
+`
+	if err := os.WriteFile(filepath.Join(dir, "su"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	cmd := execmd.NewCmd()
+	cmd.PTY = true
+	cmd.Become = &execmd.Become{Method: execmd.BecomeSu, Password: "secret"}
+	res, err := cmd.Run("echo authenticated")
+	if err != nil {
+		t.Fatalf("Failed to run command: %v, %q", err, res.Stdout)
+	}
+	if stdout := res.Stdout.String(); !strings.Contains(stdout, "authenticated\r\n") || strings.Contains(stdout, "secret") {
+		t.Errorf("Unexpected su output: %q", stdout)
+	}
+}
+
+func TestCmd_PTYExpect(t *testing.T) {
+	cmd := execmd.NewCmd()
+	cmd.PTY = true
+
+	res, err := cmd.RunExpect("printf 'Password: '; stty -echo; read p; stty echo; echo; [ -t 0 ] && echo \"got $p\"", []execmd.ExpectStep{
+		{Pattern: "Password: ", Response: "s3cret\n", Secret: true},
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+
+	if !strings.Contains(res.Stdout.String(), "got ********") {
+		t.Errorf("Unexpected output: %q", res.Stdout.String())
+	}
+	if strings.Contains(res.Transcript, "s3cret") {
+		t.Errorf("Secret leaked in transcript: %q", res.Transcript)
+	}
+}
+
+func TestCmd_PTYInteractiveConsole(t *testing.T) {
+	console, input, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+	defer input.Close()
+
+	stdin := os.Stdin
+	os.Stdin = console
+	defer func() { os.Stdin = stdin }()
+
+	cmd := execmd.NewCmd()
+	cmd.PTY = true
+	cmd.Interactive = true
+
+	input.WriteString("first\n")
+	res, err := cmd.Run("read line; echo \"got $line\"", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if !strings.Contains(res.Stdout.String(), "got first") {
+		t.Errorf("Unexpected output: %q", res.Stdout.String())
+	}
+
+	// the console input typed after the command exits is left to the next reader
+	input.WriteString("next\n")
+	line := make(chan string, 1)
+	go func() {
+		l, _ := bufio.NewReader(console).ReadString('\n')
+		line <- l
+	}()
+	select {
+	case l := <-line:
+		if l != "next\n" {
+			t.Errorf("Unexpected console input: %q", l)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Console input is swallowed after the command exits")
+	}
+}
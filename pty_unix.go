@@ -0,0 +1,173 @@
+//go:build !windows
+// +build !windows
+
+package execmd
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// defaultPTYSize is the window size of pseudo-terminals when the console is not a terminal
+var defaultPTYSize = pty.Winsize{Rows: 24, Cols: 80}
+
+// ptyDrainTimeout limits reading the output left in the pseudo-terminal after the command exits,
+// background children could keep it open forever
+const ptyDrainTimeout = 500 * time.Millisecond
+
+// ptyEOF is the end-of-file character of the terminal line discipline (Ctrl-D)
+const ptyEOF = "\x04"
+
+// startPTY starts the command in a pseudo-terminal with the window size of the console. The terminal output
+// goes to stdout of the process. Interactive commands read the console switched to raw mode,
+// others read stdin followed by the end-of-file character. Console resizes (SIGWINCH) are propagated.
+func (p *Process) startPTY(interactive bool, stdin io.Reader) error {
+	size := defaultPTYSize
+	if consoleSize, err := pty.GetsizeFull(os.Stdin); err == nil && consoleSize.Rows > 0 {
+		size = *consoleSize
+	}
+
+	var console *consoleReader
+	if interactive {
+		var err error
+		if console, err = newConsoleReader(int(os.Stdin.Fd())); err != nil {
+			return err
+		}
+	}
+
+	ptmx, err := pty.StartWithSize(p.Cmd, &size)
+	if err != nil {
+		if console != nil {
+			console.stop()
+			console.Close()
+		}
+		return err
+	}
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	go func() {
+		for range resized {
+			pty.InheritSize(os.Stdin, ptmx)
+		}
+	}()
+
+	restore := func() {}
+	stopInput := func() {}
+	if interactive {
+		fd := int(os.Stdin.Fd())
+		if state, err := term.MakeRaw(fd); err == nil {
+			restore = func() { term.Restore(fd, state) }
+		}
+
+		input := make(chan struct{})
+		go func() {
+			io.Copy(ptmx, console)
+			close(input)
+		}()
+		// the console is not read after the command exits, the next read belongs to the caller
+		stopInput = func() {
+			console.stop()
+			<-input
+			console.Close()
+		}
+	} else if stdin != nil {
+		go func() {
+			if _, err := io.Copy(ptmx, stdin); err == nil {
+				io.WriteString(ptmx, ptyEOF)
+			}
+		}()
+	}
+
+	copied := make(chan struct{})
+	go func() {
+		// the read fails with EIO when the terminal is closed by all the processes
+		io.Copy(p.stdout, ptmx)
+		close(copied)
+	}()
+
+	p.release = func() {
+		select {
+		case <-copied:
+		case <-time.After(ptyDrainTimeout):
+		}
+		ptmx.Close()
+		<-copied
+		stopInput()
+
+		signal.Stop(resized)
+		close(resized)
+		restore()
+	}
+
+	return nil
+}
+
+// consoleReader reads the console until it's stopped. A read waits for input with poll along with
+// a pipe closed on stop, so no console input is consumed after the reader is stopped.
+type consoleReader struct {
+	fd int
+	// stopped becomes readable (EOF) when wake is closed
+	stopped *os.File
+	wake    *os.File
+}
+
+func newConsoleReader(fd int) (*consoleReader, error) {
+	stopped, wake, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return &consoleReader{fd: fd, stopped: stopped, wake: wake}, nil
+}
+
+// Read waits for the console input and reads it, it returns io.EOF when the reader is stopped.
+func (c *consoleReader) Read(b []byte) (int, error) {
+	fds := []unix.PollFd{
+		{Fd: int32(c.fd), Events: unix.POLLIN},
+		{Fd: int32(c.stopped.Fd()), Events: unix.POLLIN},
+	}
+
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return 0, err
+		}
+
+		if fds[1].Revents != 0 {
+			return 0, io.EOF
+		}
+		if fds[0].Revents != 0 {
+			n, err := unix.Read(c.fd, b)
+			if err == unix.EINTR || err == unix.EAGAIN {
+				continue
+			}
+			if n <= 0 && err == nil {
+				return 0, io.EOF
+			}
+			if n < 0 {
+				n = 0
+			}
+			return n, err
+		}
+	}
+}
+
+// stop ends the pending and the next reads
+func (c *consoleReader) stop() {
+	c.wake.Close()
+}
+
+// Close releases the pipe, the reader must be stopped and not read anymore
+func (c *consoleReader) Close() error {
+	return c.stopped.Close()
+}
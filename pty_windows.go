@@ -0,0 +1,11 @@
+package execmd
+
+import (
+	"errors"
+	"io"
+)
+
+// startPTY is not supported on windows
+func (p *Process) startPTY(interactive bool, stdin io.Reader) error {
+	return errors.New("execmd: pseudo-terminals are not supported on windows")
+}
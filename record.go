@@ -0,0 +1,203 @@
+package execmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default terminal size of recordings
+const (
+	DefaultCastWidth  = 80
+	DefaultCastHeight = 24
+)
+
+// CastHeader is the header of an asciicast v2 recording, the first line of the file.
+type CastHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Title     string `json:"title,omitempty"`
+}
+
+// Recorder records the output of commands as it is displayed, with the prefixes and colors,
+// in the asciicast v2 format, so it could be replayed with the original timing, see Replay.
+// Set it to Cmd.Recorder, SSHCmd.Recorder or ClusterSSHCmd.Recorder to record a command or a cluster run.
+// It is safe to record several commands at once, the output is recorded line by line.
+type Recorder struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	closer  io.Closer
+	started time.Time
+	err     error
+}
+
+// NewRecorder writes the asciicast header to w and returns the recorder of the output to w.
+// The size defaults to DefaultCastWidth x DefaultCastHeight and the timestamp to the current time.
+func NewRecorder(w io.Writer, header CastHeader) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), started: time.Now()}
+
+	header.Version = 2
+	if header.Width <= 0 {
+		header.Width = DefaultCastWidth
+	}
+	if header.Height <= 0 {
+		header.Height = DefaultCastHeight
+	}
+	if header.Timestamp == 0 {
+		header.Timestamp = r.started.Unix()
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	r.w.Write(data)
+	r.w.WriteByte('\n')
+	if err := r.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write recording header: %w", err)
+	}
+
+	return r, nil
+}
+
+// CreateRecording creates the asciicast file and returns its recorder, .Close() closes the file.
+func CreateRecording(path, title string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewRecorder(f, CastHeader{Title: title})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+
+	return r, nil
+}
+
+// record writes the displayed text as an output event, line endings are written as a terminal shows them
+func (r *Recorder) record(text string) {
+	if text == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	elapsed := time.Since(r.started).Round(time.Microsecond).Seconds()
+	text = strings.Replace(strings.Replace(text, "\r\n", "\n", -1), "\n", "\r\n", -1)
+
+	data, _ := json.Marshal([]interface{}{elapsed, "o", text})
+	r.w.Write(data)
+	r.w.WriteByte('\n')
+	r.err = r.w.Flush()
+}
+
+// Write records p as displayed output, so other output than the commands' could be recorded,
+// e.g. the results printed after a run: io.MultiWriter(os.Stdout, recorder).
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.record(string(p))
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Err returns the error of writing the recording, the output is not recorded after an error.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Close flushes the recording and closes the file of CreateRecording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.w.Flush()
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+		r.closer = nil
+	}
+	if r.err == nil {
+		r.err = err
+	}
+
+	return err
+}
+
+// ErrInvalidCast is returned by Replay if the recording is not in the asciicast v2 format.
+var ErrInvalidCast = errors.New("invalid asciicast v2 recording")
+
+// Replay writes the output of the asciicast recording to w with the original timing.
+// The optional speed speeds up (e.g. 2) or slows down (e.g. 0.5) the replay.
+func Replay(w io.Writer, r io.Reader, speed ...float64) error {
+	factor := 1.0
+	if len(speed) > 0 && speed[0] > 0 {
+		factor = speed[0]
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: no header", ErrInvalidCast)
+	}
+	var header CastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("%w: bad header", ErrInvalidCast)
+	}
+
+	started := time.Now()
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("%w: bad event on line %d", ErrInvalidCast, line+1)
+		}
+		at, ok := event[0].(float64)
+		kind, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if !ok {
+			return fmt.Errorf("%w: bad event time on line %d", ErrInvalidCast, line+1)
+		}
+		// input and other events are not displayed
+		if kind != "o" {
+			continue
+		}
+
+		delay := time.Duration(at/factor*float64(time.Second)) - time.Since(started)
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
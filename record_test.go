@@ -0,0 +1,151 @@
+package execmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func decodeCast(t *testing.T, data string) (execmd.CastHeader, [][]interface{}) {
+	t.Helper()
+
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	var header execmd.CastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("Invalid header %q: %v", lines[0], err)
+	}
+
+	var events [][]interface{}
+	for _, line := range lines[1:] {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil || len(event) != 3 {
+			t.Fatalf("Invalid event %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := execmd.NewRecorder(&buf, execmd.CastHeader{Title: "test"})
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+
+	cmd := execmd.NewCmd()
+	cmd.Recorder = rec
+	cmd.PrefixStdout = "out> "
+	cmd.PrefixStderr = "err> "
+	captureOutput(t, func() {
+		cmd.Run("echo one; sleep 0.2; echo two >&2; printf three")
+	})
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Failed to close recorder: %v", err)
+	}
+
+	header, events := decodeCast(t, buf.String())
+	if header.Version != 2 || header.Width != execmd.DefaultCastWidth || header.Height != execmd.DefaultCastHeight ||
+		header.Title != "test" || header.Timestamp == 0 {
+		t.Errorf("Unexpected header: %+v", header)
+	}
+
+	var output []string
+	for _, event := range events {
+		if event[1] != "o" {
+			t.Errorf("Unexpected event type: %v", event)
+		}
+		output = append(output, event[2].(string))
+	}
+	if len(output) != 4 || !strings.Contains(output[0], "echo one") ||
+		output[1] != "out> one\r\n" || output[2] != "err> two\r\n" || output[3] != "out> three\r\n" {
+		t.Errorf("Unexpected output events: %q", output)
+	}
+	if at := events[2][0].(float64); at < 0.2 {
+		t.Errorf("Unexpected timing of the delayed line: %v", at)
+	}
+
+	// muted output is not displayed, so it's not recorded
+	buf.Reset()
+	rec, _ = execmd.NewRecorder(&buf, execmd.CastHeader{})
+	cmd.Recorder = rec
+	cmd.MuteCmd, cmd.MuteStdout = true, true
+	cmd.Run("echo hidden")
+	rec.Close()
+	if _, events := decodeCast(t, buf.String()); len(events) != 0 {
+		t.Errorf("Muted output is recorded: %v", events)
+	}
+
+	// other output is recorded by writing it to the recorder
+	buf.Reset()
+	rec, _ = execmd.NewRecorder(&buf, execmd.CastHeader{})
+	if n, err := fmt.Fprintln(rec, "summary"); n != len("summary\n") || err != nil {
+		t.Errorf("Failed to write to recorder: %d, %v", n, err)
+	}
+	rec.Close()
+	if _, events := decodeCast(t, buf.String()); len(events) != 1 || events[0][2] != "summary\r\n" {
+		t.Errorf("Unexpected written events: %v", events)
+	}
+}
+
+func TestClusterSSHCmd_Recorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec, _ := execmd.NewRecorder(&buf, execmd.CastHeader{})
+
+	cluster := execmd.NewClusterSSHCmd([]string{"host-01", "host-02"})
+	cluster.Recorder = rec
+	captureOutput(t, func() {
+		cluster.Run("echo hi")
+	})
+	rec.Close()
+
+	_, events := decodeCast(t, buf.String())
+	var output string
+	for _, event := range events {
+		output += event[2].(string)
+	}
+	for _, host := range cluster.Cmds {
+		if !strings.Contains(output, host.Host) {
+			t.Errorf("No output of %s recorded: %q", host.Host, output)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	cast := `{"version":2,"width":80,"height":24}
+[0.1, "o", "one\r\n"]
+[0.2, "i", "input"]
+[0.3, "o", "two\r\n"]
+`
+
+	var out bytes.Buffer
+	started := time.Now()
+	if err := execmd.Replay(&out, strings.NewReader(cast)); err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if out.String() != "one\r\ntwo\r\n" {
+		t.Errorf("Unexpected replay: %q", out.String())
+	}
+	if time.Since(started) < 300*time.Millisecond {
+		t.Errorf("Original timing is not kept, replay took %s", time.Since(started))
+	}
+
+	out.Reset()
+	started = time.Now()
+	execmd.Replay(&out, strings.NewReader(cast), 10)
+	if time.Since(started) > 200*time.Millisecond || out.String() != "one\r\ntwo\r\n" {
+		t.Errorf("Unexpected fast replay in %s: %q", time.Since(started), out.String())
+	}
+
+	for _, invalid := range []string{"", `{"version":1}`, "{\"version\":2}\n[\"bad\"]\n"} {
+		if err := execmd.Replay(&out, strings.NewReader(invalid)); !errors.Is(err, execmd.ErrInvalidCast) {
+			t.Errorf("Expected invalid recording error for %q, got: %v", invalid, err)
+		}
+	}
+}
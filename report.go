@@ -0,0 +1,224 @@
+package execmd
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxReportDetails is the length of the stderr line or the error shown in reports
+const maxReportDetails = 120
+
+// ReportRow is the result of a host in ClusterReport.
+type ReportRow struct {
+	Host     string
+	Status   ResultStatus
+	ExitCode int
+	Duration time.Duration
+	// Details is the last line of stderr, or the error if the command printed nothing to stderr
+	Details string
+}
+
+// ClusterReport is a summary table of a cluster run with a row per host,
+// the hosts with failures go first, see NewClusterReport.
+type ClusterReport struct {
+	Rows    []ReportRow
+	Summary ClusterSummary
+}
+
+// reportOrder sorts the hosts with failures before the others
+var reportOrder = map[ResultStatus]int{
+	StatusFailed:      0,
+	StatusTimeout:     1,
+	StatusUnreachable: 2,
+	StatusSkipped:     3,
+	StatusOK:          4,
+}
+
+// NewClusterReport returns the report of the cluster results, the rows are sorted by status:
+// failed, timeout, unreachable, skipped and ok hosts, in the order of the results within a status.
+func NewClusterReport(results []ClusterRes) ClusterReport {
+	report := ClusterReport{Summary: Summarize(results)}
+	for _, r := range results {
+		row := ReportRow{
+			Host:     r.Host,
+			Status:   r.Status(),
+			ExitCode: exitCode(r.Err),
+			Duration: r.Res.Duration,
+			Details:  lastLine(bufferString(r.Res.Stderr)),
+		}
+		if row.Details == "" && r.Err != nil {
+			row.Details = r.Err.Error()
+		}
+		row.Details = truncate(row.Details, maxReportDetails)
+
+		report.Rows = append(report.Rows, row)
+	}
+
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		return reportOrder[report.Rows[i].Status] < reportOrder[report.Rows[j].Status]
+	})
+
+	return report
+}
+
+// Totals returns the number of hosts by status, e.g. "5 hosts: 3 ok, 1 failed, 1 unreachable".
+func (r ClusterReport) Totals() string {
+	hosts := fmt.Sprintf("%d hosts", len(r.Rows))
+	if len(r.Rows) == 1 {
+		hosts = "1 host"
+	}
+
+	var counts []string
+	for _, group := range []struct {
+		status ResultStatus
+		hosts  []string
+	}{
+		{StatusOK, r.Summary.OK},
+		{StatusFailed, r.Summary.Failed},
+		{StatusTimeout, r.Summary.Timeout},
+		{StatusUnreachable, r.Summary.Unreachable},
+		{StatusSkipped, r.Summary.Skipped},
+	} {
+		if len(group.hosts) > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", len(group.hosts), group.status))
+		}
+	}
+
+	return hosts + ": " + strings.Join(counts, ", ")
+}
+
+// WriteText renders the report as a plain text table with colored statuses, followed by the totals.
+func (r ClusterReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSTATUS\tEXIT\tDURATION\tSTDERR")
+	for _, row := range r.Rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", row.Host, colorStatus(row.Status), row.exitCode(), row.duration(), row.Details)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w, colorStrong(r.Totals()))
+	return err
+}
+
+// WriteMarkdown renders the report as a Markdown table, followed by the totals.
+func (r ClusterReport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("| Host | Status | Exit | Duration | Stderr |\n")
+	b.WriteString("|------|--------|-----:|---------:|--------|\n")
+	for _, row := range r.Rows {
+		status := string(row.Status)
+		if row.Status != StatusOK {
+			status = "**" + status + "**"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n",
+			escapeMarkdown(row.Host), status, row.exitCode(), row.duration(), escapeMarkdown(row.Details))
+	}
+	fmt.Fprintf(&b, "\n**%s**\n", r.Totals())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<table>
+<thead>
+<tr><th>Host</th><th>Status</th><th>Exit</th><th>Duration</th><th>Stderr</th></tr>
+</thead>
+<tbody>
+{{- range .Rows}}
+<tr><td>{{.Host}}</td><td style="color: {{.Color}}">{{.Status}}</td><td>{{.Exit}}</td><td>{{.Duration}}</td><td><code>{{.Details}}</code></td></tr>
+{{- end}}
+</tbody>
+<tfoot>
+<tr><td colspan="5">{{.Totals}}</td></tr>
+</tfoot>
+</table>
+`))
+
+// htmlColors are the colors of statuses in HTML reports
+var htmlColors = map[ResultStatus]string{
+	StatusOK:          "green",
+	StatusFailed:      "red",
+	StatusTimeout:     "orange",
+	StatusUnreachable: "red",
+	StatusSkipped:     "gray",
+}
+
+// WriteHTML renders the report as an HTML table with the totals in the footer.
+func (r ClusterReport) WriteHTML(w io.Writer) error {
+	type htmlRow struct {
+		Host, Status, Color, Exit, Duration, Details string
+	}
+
+	data := struct {
+		Rows   []htmlRow
+		Totals string
+	}{Totals: r.Totals()}
+	for _, row := range r.Rows {
+		data.Rows = append(data.Rows, htmlRow{
+			Host:     row.Host,
+			Status:   string(row.Status),
+			Color:    htmlColors[row.Status],
+			Exit:     row.exitCode(),
+			Duration: row.duration(),
+			Details:  row.Details,
+		})
+	}
+
+	return reportHTML.Execute(w, data)
+}
+
+// exitCode returns the exit code, or "-" if the command didn't exit on its own
+func (row ReportRow) exitCode() string {
+	if row.Status == StatusSkipped || row.ExitCode < 0 {
+		return "-"
+	}
+	return strconv.Itoa(row.ExitCode)
+}
+
+// duration returns the rounded duration, or "-" if the host was not started
+func (row ReportRow) duration() string {
+	if row.Status == StatusSkipped {
+		return "-"
+	}
+	return row.Duration.Round(time.Millisecond).String()
+}
+
+// colorStatus colors the status of the host by severity
+func colorStatus(status ResultStatus) string {
+	switch status {
+	case StatusOK:
+		return colorOK(string(status))
+	case StatusTimeout, StatusSkipped:
+		return colorWarn(string(status))
+	default:
+		return colorErr(string(status))
+	}
+}
+
+// lastLine returns the last non-empty line of the text
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\r\n"), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// truncate shortens the text to max runes with an ellipsis
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// escapeMarkdown escapes the text for a Markdown table cell
+func escapeMarkdown(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "`", "\\`", "*", `\*`, "_", `\_`).Replace(text)
+}
@@ -0,0 +1,124 @@
+package execmd_test
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+// clusterResults returns the results of a run on four hosts: ok, failed with exit code 3,
+// unreachable and timed out
+func clusterResults() []execmd.ClusterRes {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	sshExitErr := exec.Command("sh", "-c", "exit 255").Run()
+	sshErr := &execmd.SSHError{
+		Kind:    execmd.SSHErrRefused,
+		Message: "ssh: connect to host host-03 port 22: Connection refused",
+		Err:     sshExitErr,
+	}
+
+	results := []execmd.ClusterRes{
+		newClusterRes("host-01", "ok\n", "", nil),
+		newClusterRes("host-02", "", "check <failed>\nlast | line\n", exitErr),
+		newClusterRes("host-03", "", sshErr.Message+"\n", sshErr),
+		newClusterRes("host-04", "", "", errors.New("signal: killed")),
+	}
+	results[0].Res.Duration = 12 * time.Millisecond
+	results[1].Res.ExitCode, results[1].Res.Duration = 3, 205*time.Millisecond
+	results[2].Res.ExitCode, results[2].SSHErr = 255, sshErr
+	results[3].Res.ExitCode, results[3].Res.Duration, results[3].Res.TimedOut = -1, time.Second, true
+
+	return results
+}
+
+func TestNewClusterReport(t *testing.T) {
+	results := clusterResults()
+
+	report := execmd.NewClusterReport(results)
+	if len(report.Rows) != 4 {
+		t.Fatalf("Unexpected rows: %+v", report.Rows)
+	}
+
+	for i, expected := range []struct {
+		host   string
+		status execmd.ResultStatus
+	}{
+		{"host-02", execmd.StatusFailed},
+		{"host-04", execmd.StatusTimeout},
+		{"host-03", execmd.StatusUnreachable},
+		{"host-01", execmd.StatusOK},
+	} {
+		if row := report.Rows[i]; row.Host != expected.host || row.Status != expected.status {
+			t.Errorf("Unexpected row %d: %+v", i, row)
+		}
+	}
+
+	if row := report.Rows[0]; row.ExitCode != 3 || row.Details != "last | line" {
+		t.Errorf("Unexpected failed row: %+v", row)
+	}
+	if row := report.Rows[2]; row.ExitCode != 255 || !strings.HasPrefix(row.Details, "ssh: connect to host host-03") {
+		t.Errorf("Unexpected unreachable row: %+v", row)
+	}
+
+	totals := "4 hosts: 1 ok, 1 failed, 1 timeout, 1 unreachable"
+	if report.Totals() != totals {
+		t.Errorf("Unexpected totals: %s", report.Totals())
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf); err != nil {
+		t.Fatalf("Failed to write text report: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 || !strings.HasPrefix(lines[0], "HOST") || !strings.HasPrefix(lines[1], "host-02") ||
+		!strings.Contains(lines[5], totals) {
+		t.Errorf("Unexpected text report:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := report.WriteMarkdown(&buf); err != nil {
+		t.Fatalf("Failed to write Markdown report: %v", err)
+	}
+	if !strings.Contains(buf.String(), "| host-02 | **failed** | 3 |") || !strings.Contains(buf.String(), `last \| line |`) ||
+		!strings.Contains(buf.String(), "| host-04 | **timeout** | - | 1s |") || !strings.Contains(buf.String(), "**"+totals+"**") {
+		t.Errorf("Unexpected Markdown report:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := report.WriteHTML(&buf); err != nil {
+		t.Fatalf("Failed to write HTML report: %v", err)
+	}
+	if !strings.Contains(buf.String(), `<td>host-02</td><td style="color: red">failed</td><td>3</td>`) ||
+		!strings.Contains(buf.String(), totals) {
+		t.Errorf("Unexpected HTML report:\n%s", buf.String())
+	}
+}
+
+func TestNewClusterReport_Skipped(t *testing.T) {
+	results := []execmd.ClusterRes{
+		newClusterRes("host-01", "", "", errors.New("cluster timeout exceeded: signal: killed")),
+		newClusterRes("host-02", "", "", execmd.ErrClusterTimeout),
+		newClusterRes("host-03", "", "", execmd.ErrClusterTimeout),
+	}
+	results[0].Res.TimedOut = true
+	results[1].Skipped, results[2].Skipped = true, true
+
+	report := execmd.NewClusterReport(results)
+	if report.Totals() != "3 hosts: 1 timeout, 2 skipped" {
+		t.Errorf("Unexpected totals: %s", report.Totals())
+	}
+	if row := report.Rows[2]; row.Host != "host-03" || row.Status != execmd.StatusSkipped {
+		t.Errorf("Unexpected skipped row: %+v", row)
+	}
+
+	var buf bytes.Buffer
+	report.WriteMarkdown(&buf)
+	if !strings.Contains(buf.String(), "| host-03 | **skipped** | - | - |") {
+		t.Errorf("Unexpected Markdown report:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,181 @@
+package execmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RsyncOptions customize directory sync with .Rsync().
+type RsyncOptions struct {
+	// Exclude patterns are passed to rsync with --exclude
+	Exclude []string
+	// Delete removes remote files which don't exist locally
+	Delete bool
+	// Checksum compares files by checksum instead of size and modification time
+	Checksum bool
+	// DryRun reports the changes without making them
+	DryRun bool
+	// Args are passed to rsync as is, e.g. --chmod=D755,F644
+	Args []string
+	// Timeout limits the whole sync
+	Timeout time.Duration
+}
+
+// RsyncChange is a file changed by rsync, parsed from the itemized output (rsync --itemize-changes).
+type RsyncChange struct {
+	Path string
+	// Update is the update type: '<' sent, '>' received, 'c' created or changed locally,
+	// 'h' hard link, '.' only attributes changed, '*' message, e.g. deleting
+	Update byte
+	// FileType is 'f' for files, 'd' for directories, 'L' for symlinks, 'D' for devices and 'S' for special files
+	FileType byte
+	// Attrs are the attribute flags, e.g. "cs.p....." for a changed checksum, size and permissions
+	Attrs   string
+	Created bool
+	Deleted bool
+}
+
+// RsyncRes is the result of rsync with the changed files.
+type RsyncRes struct {
+	CmdRes
+	Changes []RsyncChange
+}
+
+// ClusterRsyncRes is the result of rsync on a cluster host.
+type ClusterRsyncRes struct {
+	ClusterRes
+	Changes []RsyncChange
+}
+
+// Rsync syncs the local path to the remote path in archive mode (rsync -a),
+// the host connection settings (user, port, key, jump host and options) are reused for the ssh transport.
+// Changes are parsed from the recorded stdout, so they are empty if the Cmd doesn't record stdout.
+func (s *SSHCmd) Rsync(localPath, remotePath string, opts ...RsyncOptions) (RsyncRes, error) {
+	proc, err := s.startRsync(context.Background(), localPath, remotePath, rsyncOptions(opts))
+	if proc == nil {
+		return RsyncRes{}, err
+	}
+	if err == nil {
+		err = proc.Wait()
+	}
+
+	return RsyncRes{CmdRes: proc.Res, Changes: ParseRsyncChanges(bufferString(proc.Res.Stdout))}, err
+}
+
+// Rsync syncs the local path to the remote path on all hosts in parallel, see SSHCmd.Rsync().
+// It returns results with the changed files per host and the first caught error.
+func (c *ClusterSSHCmd) Rsync(localPath, remotePath string, opts ...RsyncOptions) ([]ClusterRsyncRes, error) {
+	o := rsyncOptions(opts)
+	results, err := c.runEach(func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error) {
+		return ssh.startRsync(ctx, localPath, remotePath, o)
+	})
+
+	rsyncResults := make([]ClusterRsyncRes, len(results))
+	for i, res := range results {
+		rsyncResults[i] = ClusterRsyncRes{
+			ClusterRes: res,
+			Changes:    ParseRsyncChanges(bufferString(res.Res.Stdout)),
+		}
+	}
+
+	return rsyncResults, err
+}
+
+func rsyncOptions(opts []RsyncOptions) RsyncOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return RsyncOptions{}
+}
+
+// startRsync starts rsync pushing the local path to the host.
+// The returned process is nil if the command can't be prepared.
+func (s *SSHCmd) startRsync(ctx context.Context, localPath, remotePath string, opts RsyncOptions) (*Process, error) {
+	rsyncArgs, err := s.rsyncCommand(shellQuote(localPath), shellQuote(s.remoteTarget(remotePath)), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare rsync command: %w", err)
+	}
+
+	// rsync exits with ssh exit status if the transport fails
+	startOpts := s.startOptions()
+	startOpts.mapErr = s.classifySSHError
+
+	return s.Cmd.start(ctx, strings.Join(rsyncArgs, " "), startOpts, opts.Timeout)
+}
+
+// rsyncCommand returns an rsync argument slice syncing the already quoted source to the destination
+func (s *SSHCmd) rsyncCommand(src, dst string, opts RsyncOptions) ([]string, error) {
+	connArgs, err := s.connectionArgs("-p")
+	if err != nil {
+		return nil, err
+	}
+	transport := append([]string{s.SSHExecutable}, connArgs...)
+
+	rsyncArgs := []string{s.RsyncExecutable, "-a", "--itemize-changes", "-e", shellQuote(strings.Join(transport, " "))}
+	if opts.Delete {
+		rsyncArgs = append(rsyncArgs, "--delete")
+	}
+	if opts.Checksum {
+		rsyncArgs = append(rsyncArgs, "--checksum")
+	}
+	if opts.DryRun {
+		rsyncArgs = append(rsyncArgs, "--dry-run")
+	}
+	for _, pattern := range opts.Exclude {
+		rsyncArgs = append(rsyncArgs, "--exclude="+shellQuote(pattern))
+	}
+	for _, arg := range opts.Args {
+		rsyncArgs = append(rsyncArgs, shellQuote(arg))
+	}
+
+	return append(rsyncArgs, src, dst), nil
+}
+
+// ParseRsyncChanges parses the itemized rsync output, e.g. ">f+++++++++ bin/app" or "*deleting   old.conf",
+// other output lines are skipped.
+func ParseRsyncChanges(output string) []RsyncChange {
+	var changes []RsyncChange
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		i := strings.IndexByte(line, ' ')
+		if i < 2 || !strings.ContainsRune("<>ch.*", rune(line[0])) {
+			continue
+		}
+
+		item, path := line[:i], strings.TrimLeft(line[i:], " ")
+		if path == "" {
+			continue
+		}
+
+		if item == "*deleting" {
+			changes = append(changes, RsyncChange{Path: path, Update: '*', Attrs: item[1:], Deleted: true})
+			continue
+		}
+		if item[0] == '*' || !strings.ContainsRune("fdLDS", rune(item[1])) {
+			continue
+		}
+
+		// symlinks are listed as link -> target
+		if item[1] == 'L' {
+			if j := strings.Index(path, " -> "); j >= 0 {
+				path = path[:j]
+			}
+		}
+
+		changes = append(changes, RsyncChange{
+			Path:     path,
+			Update:   item[0],
+			FileType: item[1],
+			Attrs:    item[2:],
+			Created:  strings.Trim(item[2:], "+") == "",
+		})
+	}
+
+	return changes
+}
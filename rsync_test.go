@@ -0,0 +1,85 @@
+package execmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestParseRsyncChanges(t *testing.T) {
+	output := `sending incremental file list
+cd+++++++++ bin/
+>f+++++++++ bin/app
+>fcst...... app.conf
+.d..t...... ./
+cL+++++++++ current -> releases/v2
+*deleting   old.conf
+
+sent 1,024 bytes  received 64 bytes  2,176.00 bytes/sec
+`
+
+	expected := []execmd.RsyncChange{
+		{Path: "bin/", Update: 'c', FileType: 'd', Attrs: "+++++++++", Created: true},
+		{Path: "bin/app", Update: '>', FileType: 'f', Attrs: "+++++++++", Created: true},
+		{Path: "app.conf", Update: '>', FileType: 'f', Attrs: "cst......"},
+		{Path: "./", Update: '.', FileType: 'd', Attrs: "..t......"},
+		{Path: "current", Update: 'c', FileType: 'L', Attrs: "+++++++++", Created: true},
+		{Path: "old.conf", Update: '*', Attrs: "deleting", Deleted: true},
+	}
+
+	changes := execmd.ParseRsyncChanges(output)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Unexpected changes:\n%+v\nexpected:\n%+v", changes, expected)
+	}
+}
+
+func TestClusterSSHCmd_Rsync(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "release")
+	if err := os.MkdirAll(release, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"app": "v1", "app.log": "debug"} {
+		if err := os.WriteFile(filepath.Join(release, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	remote := filepath.Join(dir, "deployed")
+	srv := execmd.NewSSHCmd(dummyHost)
+
+	res, err := srv.Rsync(release+"/", remote, execmd.RsyncOptions{Exclude: []string{"*.log"}, DryRun: true})
+	if err != nil {
+		t.Fatalf("Failed to rsync: %v", err)
+	}
+	if len(res.Changes) != 2 || res.Changes[1].Path != "app" || !res.Changes[1].Created {
+		t.Errorf("Unexpected dry run changes: %+v", res.Changes)
+	}
+	if _, err := os.Stat(remote); err == nil {
+		t.Error("Dry run created the remote directory")
+	}
+
+	if _, err := srv.Rsync(release+"/", remote, execmd.RsyncOptions{Exclude: []string{"*.log"}}); err != nil {
+		t.Fatalf("Failed to rsync: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(remote, "stale"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := execmd.NewClusterSSHCmd([]string{dummyHost, "unreachable-host"})
+	results, err := cluster.Rsync(release+"/", remote, execmd.RsyncOptions{Exclude: []string{"*.log"}, Delete: true})
+	if err == nil {
+		t.Error("Expected error on unreachable host, but got nil")
+	}
+
+	expected := []execmd.RsyncChange{{Path: "stale", Update: '*', Attrs: "deleting", Deleted: true}}
+	if !reflect.DeepEqual(results[0].Changes, expected) {
+		t.Errorf("Unexpected changes on host %s: %+v", results[0].Host, results[0].Changes)
+	}
+	if results[1].SSHErr == nil {
+		t.Errorf("Expected ssh error on host %s, got: %v", results[1].Host, results[1].Err)
+	}
+}
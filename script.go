@@ -0,0 +1,89 @@
+package execmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultInterpreter runs scripts read from stdin with bash, see Script.Interpreter
+const DefaultInterpreter = "bash -s --"
+
+// Script is a local script to run on remote hosts. The script body is streamed over ssh stdin
+// to the interpreter, so it's not limited by shell quoting or the maximum command line length.
+type Script struct {
+	// Interpreter is the remote command reading the script from stdin, followed by the arguments,
+	// e.g. "python3 -" or "sh -s --", DefaultInterpreter if empty
+	Interpreter string
+	Body        []byte
+	Args        []string
+}
+
+// NewScript returns a bash script with the arguments.
+func NewScript(body string, args ...string) Script {
+	return Script{Body: []byte(body), Args: args}
+}
+
+// LoadScript reads the script from a local file, it runs with bash unless .Interpreter is set.
+func LoadScript(path string, args ...string) (Script, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return Script{}, fmt.Errorf("failed to load script: %w", err)
+	}
+
+	return Script{Body: body, Args: args}, nil
+}
+
+// command returns the remote interpreter command with the quoted arguments
+func (s Script) command() string {
+	command := s.Interpreter
+	if command == "" {
+		command = DefaultInterpreter
+	}
+
+	for _, arg := range s.Args {
+		command += " " + shellQuote(arg)
+	}
+
+	return command
+}
+
+// RunScript runs the script on the host and waits for it to complete.
+func (s *SSHCmd) RunScript(script Script, timeout ...time.Duration) (CmdRes, error) {
+	return s.RunScriptContext(context.Background(), script, timeout...)
+}
+
+// RunScriptContext runs the script like .RunScript(), the script is killed when ctx is done.
+func (s *SSHCmd) RunScriptContext(ctx context.Context, script Script, timeout ...time.Duration) (CmdRes, error) {
+	proc, err := s.startScript(ctx, script, timeout...)
+	if proc == nil {
+		return CmdRes{}, err
+	}
+	if err != nil {
+		return proc.Res, err
+	}
+
+	err = proc.Wait()
+	return proc.Res, err
+}
+
+// startScript starts the interpreter on the host with the script body on stdin.
+func (s *SSHCmd) startScript(ctx context.Context, script Script, timeout ...time.Duration) (*Process, error) {
+	return s.startWithIO(ctx, script.command(), processIO{stdin: bytes.NewReader(script.Body)}, timeout...)
+}
+
+// RunScript runs the same script on all hosts in parallel and waits for the results,
+// every host reads its own copy of the script body.
+// It returns results and the first caught error.
+func (c *ClusterSSHCmd) RunScript(script Script, timeout ...time.Duration) ([]ClusterRes, error) {
+	return c.RunScriptContext(context.Background(), script, timeout...)
+}
+
+// RunScriptContext runs the script like .RunScript(), the hosts are killed when ctx is done.
+func (c *ClusterSSHCmd) RunScriptContext(ctx context.Context, script Script, timeout ...time.Duration) ([]ClusterRes, error) {
+	return c.runEachContext(ctx, func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error) {
+		return ssh.startScript(ctx, script, timeout...)
+	})
+}
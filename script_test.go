@@ -0,0 +1,57 @@
+package execmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestSSHCmd_RunScript(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+
+	// quotes and a body over the ARG_MAX limit would break the single-quoted ssh command
+	body := "echo \"it's $1 and $2\"\n# " + strings.Repeat("x", 4<<20) + "\necho done\n"
+	res, err := srv.RunScript(execmd.NewScript(body, "one", "two words"))
+	if err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	if res.Stdout.String() != "it's one and two words\ndone\n" {
+		t.Errorf("Unexpected stdout output: %s", res.Stdout.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(path, []byte("import sys\nprint(sys.argv[1:])\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script, err := execmd.LoadScript(path, "-v")
+	if err != nil {
+		t.Fatalf("Failed to load script: %v", err)
+	}
+
+	script.Interpreter = "python3 -"
+	if res, err := srv.RunScript(script); err != nil || res.Stdout.String() != "['-v']\n" {
+		t.Errorf("Unexpected python script result: %q, %v", res.Stdout, err)
+	}
+}
+
+func TestClusterSSHCmd_RunScript(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	res, err := cluster.RunScript(execmd.NewScript("read -r line; echo \"$1 $line\"\nread by the script\nexit 3\n", "host"))
+	if err == nil {
+		t.Error("Expected error, but got nil")
+	}
+
+	for _, r := range res {
+		if r.Res.ExitCode != 3 {
+			t.Errorf("Unexpected exit code on host %s: %d", r.Host, r.Res.ExitCode)
+		}
+		// the script reads its own next line from stdin
+		if r.Res.Stdout.String() != "host read by the script\n" {
+			t.Errorf("Unexpected stdout on host %s: %s", r.Host, r.Res.Stdout)
+		}
+	}
+}
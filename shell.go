@@ -0,0 +1,367 @@
+package execmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historySize limits the commands kept in the shell history
+const historySize = 1000
+
+// clusterShellHelp lists the shell commands
+const clusterShellHelp = `Every line runs on all the active hosts, shell commands start with ':'
+  :hosts               list the active and excluded hosts
+  :exclude PATTERN...  remove hosts from the active set, e.g. :exclude web[03-05]
+  :include PATTERN...  add hosts back to the active set, unknown hosts join the cluster
+  :parallel            run commands on all the hosts at once (default)
+  :serial              run commands on one host after another
+  :aggregate           print the output grouped by hosts with the same output (default)
+  :stream              print the output of every host as it is written
+  :history             list previous commands, !N runs command N again, !! the last one
+  :help                show this help
+  :quit                exit the shell, as Ctrl-D
+Ctrl-C kills the running command on all the hosts.
+`
+
+// ErrNoActiveHosts is returned when a shell command runs with all the hosts excluded
+var ErrNoActiveHosts = errors.New("no active hosts")
+
+// ClusterShell is an interactive shell for ad-hoc commands on the cluster, like cssh: every line typed
+// runs on all the active hosts, and the output is aggregated by hosts with the same output.
+// Lines starting with ':' manage the active hosts, the mode and the history, see :help.
+type ClusterShell struct {
+	Cluster *ClusterSSHCmd
+	// Serial runs commands on one host after another instead of all the hosts in parallel
+	Serial bool
+	// Stream prints the output of every host as it is written instead of aggregating it after the command
+	Stream bool
+	// Timeout limits commands on every host, no limit if zero
+	Timeout time.Duration
+	// History holds the commands typed, oldest first
+	History []string
+	// HistoryFile keeps the history between sessions if set
+	HistoryFile string
+
+	hosts         []ClusterCmd
+	excluded      map[string]bool
+	historyLoaded bool
+}
+
+// NewClusterShell initializes ClusterShell with all the cluster hosts active.
+func NewClusterShell(cluster *ClusterSSHCmd) *ClusterShell {
+	return &ClusterShell{
+		Cluster:  cluster,
+		hosts:    append([]ClusterCmd{}, cluster.Cmds...),
+		excluded: map[string]bool{},
+	}
+}
+
+// Run reads lines from in and runs them until EOF or :quit, the output goes to out.
+// Errors of the commands are printed, it returns only errors of reading in.
+func (s *ClusterShell) Run(in io.Reader, out io.Writer) error {
+	if err := s.loadHistory(); err != nil {
+		fmt.Fprintf(out, "%s\n", colorErr(err.Error()))
+	}
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(out, "%s> ", colorStrong(s.prompt()))
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		command, err := s.expandHistory(line)
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", colorErr(err.Error()))
+			continue
+		}
+		if command != line {
+			fmt.Fprintln(out, command)
+			line = command
+		}
+		s.addHistory(line)
+
+		if strings.HasPrefix(line, ":") {
+			quit, err := s.Command(line, out)
+			if err != nil {
+				fmt.Fprintf(out, "%s\n", colorErr(err.Error()))
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+
+		if _, err := s.Exec(line, out); errors.Is(err, ErrNoActiveHosts) {
+			fmt.Fprintf(out, "%s\n", colorErr(err.Error()))
+		}
+	}
+}
+
+// Command runs the shell command, e.g. ":exclude web03", it returns true on :quit.
+func (s *ClusterShell) Command(line string, out io.Writer) (quit bool, err error) {
+	fields := strings.Fields(strings.TrimPrefix(line, ":"))
+	if len(fields) == 0 {
+		return false, fmt.Errorf("empty shell command, see :help")
+	}
+
+	name, args := fields[0], fields[1:]
+	switch name {
+	case "hosts":
+		active := s.activeHosts()
+		fmt.Fprintf(out, "active: %d %s\n", len(active), FoldHosts(active))
+		if excluded := s.excludedHosts(); len(excluded) > 0 {
+			fmt.Fprintf(out, "excluded: %d %s\n", len(excluded), FoldHosts(excluded))
+		}
+	case "exclude", "include":
+		if len(args) == 0 {
+			return false, fmt.Errorf(":%s requires host patterns", name)
+		}
+		return false, s.setHosts(args, name == "exclude")
+	case "parallel", "serial":
+		s.Serial = name == "serial"
+		fmt.Fprintf(out, "running commands in %s\n", name)
+	case "aggregate", "stream":
+		s.Stream = name == "stream"
+		fmt.Fprintf(out, "output mode: %s\n", name)
+	case "history":
+		for i, command := range s.History {
+			fmt.Fprintf(out, "%5d  %s\n", i+1, command)
+		}
+	case "help":
+		io.WriteString(out, clusterShellHelp)
+	case "quit", "exit", "q":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown shell command :%s, see :help", name)
+	}
+
+	return false, nil
+}
+
+// Exec runs the command on the active hosts and prints the output and the summary to out.
+// The command is killed on all the hosts on interrupt (Ctrl-C) or SIGTERM. It returns results and the first caught error.
+func (s *ClusterShell) Exec(command string, out io.Writer) ([]ClusterRes, error) {
+	ctx, stop := CancelOnInterrupt(context.Background())
+	defer stop()
+
+	return s.ExecContext(ctx, command, out)
+}
+
+// ExecContext runs the command like .Exec(), the command is killed on all the hosts when ctx is done.
+func (s *ClusterShell) ExecContext(ctx context.Context, command string, out io.Writer) ([]ClusterRes, error) {
+	cluster := s.cluster()
+	if len(cluster.Cmds) == 0 {
+		return nil, ErrNoActiveHosts
+	}
+
+	cp, err := cluster.startContext(ctx, cluster.sameCommand(command), !s.Serial, s.Timeout)
+	results, err := cluster.waitResults(cp, err)
+	if results == nil {
+		fmt.Fprintf(out, "%s\n", colorErr(err.Error()))
+		return nil, err
+	}
+
+	if !s.Stream {
+		WriteAggregated(out, AggregateResults(results))
+	}
+	fmt.Fprintln(out, Summarize(results))
+
+	return results, err
+}
+
+// cluster returns the cluster of the active hosts, with muted output of the hosts unless .Stream is set.
+func (s *ClusterShell) cluster() *ClusterSSHCmd {
+	c := s.Cluster
+	cluster := &ClusterSSHCmd{
+		Cwd:         c.Cwd,
+		StopOnError: c.StopOnError,
+		Become:      c.Become,
+		Recorder:    c.Recorder,
+		Audit:       c.Audit,
+		Template:    c.Template,
+		Timeout:     c.Timeout,
+		MaxParallel: c.MaxParallel,
+	}
+
+	for _, cmd := range s.hosts {
+		if s.excluded[cmd.Host] {
+			continue
+		}
+		if !s.Stream {
+			// the typed commands are audited, unlike internal muted commands
+			audit := cmd.SSHCmd.startOptions().audit
+			cmd.SSHCmd = *cmd.SSHCmd.muted()
+			cmd.SSHCmd.Audit = audit
+		}
+		cluster.Cmds = append(cluster.Cmds, cmd)
+	}
+	cluster.Errors = make([]error, len(cluster.Cmds))
+
+	return cluster
+}
+
+// setHosts excludes the hosts matching the patterns or includes them back,
+// unknown hosts are added to the cluster. .Cluster.Cmds is updated with the active hosts.
+func (s *ClusterShell) setHosts(patterns []string, exclude bool) error {
+	var hosts []string
+	for _, pattern := range patterns {
+		expanded, err := ExpandHosts(pattern)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, expanded...)
+	}
+
+	known := map[string]bool{}
+	for _, cmd := range s.hosts {
+		known[cmd.Host] = true
+	}
+
+	// a typo doesn't leave the active set half changed
+	for _, host := range hosts {
+		if exclude && !known[host] {
+			return fmt.Errorf("unknown host %s", host)
+		}
+	}
+
+	for _, host := range hosts {
+		switch {
+		case exclude:
+			s.excluded[host] = true
+		case !known[host]:
+			known[host] = true
+			s.hosts = append(s.hosts, ClusterCmd{Host: host, SSHCmd: *NewSSHCmd(host)})
+		default:
+			delete(s.excluded, host)
+		}
+	}
+
+	var cmds []ClusterCmd
+	for _, cmd := range s.hosts {
+		if !s.excluded[cmd.Host] {
+			cmds = append(cmds, cmd)
+		}
+	}
+	s.Cluster.Cmds = cmds
+
+	s.Cluster.mu.Lock()
+	defer s.Cluster.mu.Unlock()
+	s.Cluster.Errors = make([]error, len(cmds))
+
+	return nil
+}
+
+// activeHosts returns the hosts the commands run on, in the cluster order
+func (s *ClusterShell) activeHosts() []string {
+	var hosts []string
+	for _, cmd := range s.hosts {
+		if !s.excluded[cmd.Host] {
+			hosts = append(hosts, cmd.Host)
+		}
+	}
+	return hosts
+}
+
+// excludedHosts returns the hosts removed from the active set, in the cluster order
+func (s *ClusterShell) excludedHosts() []string {
+	var hosts []string
+	for _, cmd := range s.hosts {
+		if s.excluded[cmd.Host] {
+			hosts = append(hosts, cmd.Host)
+		}
+	}
+	return hosts
+}
+
+// prompt returns the folded active hosts and the mode, e.g. "web[01-03] serial"
+func (s *ClusterShell) prompt() string {
+	prompt := FoldHosts(s.activeHosts())
+	if prompt == "" {
+		prompt = "(no hosts)"
+	}
+	if s.Serial {
+		prompt += " serial"
+	}
+	return prompt
+}
+
+// expandHistory replaces !! with the last command and !N with the command N of the history
+func (s *ClusterShell) expandHistory(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+
+	if line == "!!" {
+		if len(s.History) == 0 {
+			return "", errors.New("history is empty")
+		}
+		return s.History[len(s.History)-1], nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(s.History) {
+		return "", fmt.Errorf("no command %s in history", line)
+	}
+	return s.History[n-1], nil
+}
+
+// addHistory saves the command to the history and appends it to .HistoryFile if set
+func (s *ClusterShell) addHistory(line string) {
+	s.History = append(s.History, line)
+	if len(s.History) > historySize {
+		s.History = s.History[len(s.History)-historySize:]
+	}
+
+	if s.HistoryFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(s.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, line)
+}
+
+// loadHistory reads the history from .HistoryFile once, a missing file is an empty history
+func (s *ClusterShell) loadHistory() error {
+	if s.HistoryFile == "" || s.historyLoaded {
+		return nil
+	}
+	s.historyLoaded = true
+
+	data, err := os.ReadFile(s.HistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load history: %w", err)
+	}
+
+	for _, line := range splitLines(string(data)) {
+		if line = strings.TrimSpace(line); line != "" {
+			s.History = append(s.History, line)
+		}
+	}
+	if len(s.History) > historySize {
+		s.History = s.History[len(s.History)-historySize:]
+	}
+
+	return nil
+}
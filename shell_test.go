@@ -0,0 +1,100 @@
+package execmd_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestClusterShell_Run(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+	shell := execmd.NewClusterShell(cluster)
+	shell.HistoryFile = filepath.Join(t.TempDir(), "history")
+
+	script := strings.Join([]string{
+		"echo same output",
+		":exclude 127.0.0.1",
+		":hosts",
+		":serial",
+		"!1",
+		"!!",
+		":include 127.0.0.1 localhost:22",
+		":exclude host-99",
+		":parallel",
+		"!1",
+		":history",
+		":quit",
+		"echo never runs",
+	}, "\n")
+
+	var out strings.Builder
+	if err := shell.Run(strings.NewReader(script), &out); err != nil {
+		t.Fatalf("Failed to run shell: %v", err)
+	}
+	output := out.String()
+
+	for _, expected := range []string{
+		"localhost,127.0.0.1 (2)\n---------------\nsame output\n",
+		"ok: 2 localhost,127.0.0.1",
+		"active: 1 localhost\nexcluded: 1 127.0.0.1\n",
+		"running commands in serial",
+		"ok: 1 localhost",
+		"unknown host host-99",
+		"localhost,127.0.0.1,localhost:22 (3)",
+		"    2  :exclude 127.0.0.1\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in the shell output:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "never runs") {
+		t.Errorf("Shell did not quit:\n%s", output)
+	}
+
+	if len(cluster.Cmds) != 3 {
+		t.Errorf("Expected the cluster with the active hosts, got: %d", len(cluster.Cmds))
+	}
+
+	history, err := os.ReadFile(shell.HistoryFile)
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(history)), "\n"); len(lines) != 12 || lines[4] != "echo same output" {
+		t.Errorf("Unexpected history file: %q", history)
+	}
+
+	// the history is loaded by a new shell
+	next := execmd.NewClusterShell(cluster)
+	next.HistoryFile = shell.HistoryFile
+	out.Reset()
+	if err := next.Run(strings.NewReader("!1\n"), &out); err != nil {
+		t.Fatalf("Failed to run shell: %v", err)
+	}
+	if !strings.Contains(out.String(), "localhost,127.0.0.1,localhost:22 (3)\n---------------\nsame output\n") {
+		t.Errorf("Unexpected output of the history command:\n%s", out.String())
+	}
+}
+
+func TestClusterShell_Exec(t *testing.T) {
+	shell := execmd.NewClusterShell(execmd.NewClusterSSHCmd([]string{"localhost", "127.0.0.1:1"}))
+
+	var out strings.Builder
+	results, err := shell.Exec("echo hi", &out)
+	if err == nil || len(results) != 2 {
+		t.Fatalf("Expected error of the unreachable host, got: %v, %d results", err, len(results))
+	}
+	if !strings.Contains(out.String(), "ok: 1 localhost; unreachable: 1 127.0.0.1:1") {
+		t.Errorf("Unexpected summary:\n%s", out.String())
+	}
+
+	if _, err := shell.Command(":exclude localhost 127.0.0.1:1", &out); err != nil {
+		t.Fatalf("Failed to exclude hosts: %v", err)
+	}
+	if _, err := shell.Exec("echo hi", &out); !errors.Is(err, execmd.ErrNoActiveHosts) {
+		t.Errorf("Expected ErrNoActiveHosts, got: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package execmd_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestClusterShell_ExecContext(t *testing.T) {
+	shell := execmd.NewClusterShell(execmd.NewClusterSSHCmd(dummyHosts))
+
+	for _, serial := range []bool{false, true} {
+		shell.Serial = serial
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(300*time.Millisecond, cancel)
+
+		var out strings.Builder
+		start := time.Now()
+		results, err := shell.ExecContext(ctx, "sleep 5", &out)
+		if time.Since(start) > 3*time.Second {
+			t.Errorf("Command was not canceled in time: %s", time.Since(start))
+		}
+		if !errors.Is(err, execmd.ErrCanceled) {
+			t.Errorf("Expected ErrCanceled, got: %v", err)
+		}
+		for _, res := range results {
+			if !errors.Is(res.Err, execmd.ErrCanceled) {
+				t.Errorf("Expected ErrCanceled on host %s, got: %v", res.Host, res.Err)
+			}
+		}
+	}
+}
@@ -1,108 +1,408 @@
 package execmd
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
 // SSHCmd is a wrapper on Cmd to invoke ssh commands via OpenSSH binary
 type SSHCmd struct {
-	Cmd           *Cmd
-	Interactive   bool
-	SSHExecutable string
-	Host          string
-	User          string
-	Port          string
-	KeyPath       string
-	Cwd           string
+	Cmd             *Cmd
+	Interactive     bool
+	SSHExecutable   string
+	SCPExecutable   string
+	RsyncExecutable string
+	Host            string
+	User            string
+	Port            string
+	KeyPath         string
+	JumpHost        string
+	// Options are passed to ssh with -o, e.g. StrictHostKeyChecking=accept-new
+	Options []string
+	Cwd     string
+	Env     map[string]string
+	// Become runs the remote commands as another user with sudo, su or doas
+	Become *Become
+	// Recorder records the output of the host instead of Cmd.Recorder, see Recorder
+	Recorder *Recorder
+	// Audit writes audit records of the remote commands instead of Cmd.Audit, see AuditSink
+	Audit AuditSink
+	// KillRemote kills the remote process tree in the background with a follow-up ssh call
+	// when the command is killed on timeout or cancel, otherwise only the local ssh process is killed
+	// and the remote command keeps running. Commands in a forced pseudo-terminal get SIGHUP from sshd instead.
+	// The remote pid is saved to /tmp, so it requires a POSIX login shell on the host, a writable /tmp
+	// and commands which don't set their own EXIT trap. The host is not contacted again if ssh never connected.
+	KillRemote bool
 }
 
-// NewSSHCmd initializes SSHCmd with defaults and sets the target host
+// remoteKillTimeout limits the follow-up ssh call killing the remote command
+const remoteKillTimeout = 5 * time.Second
+
+// remoteKillOptions keep the follow-up ssh call from prompting or hanging on an unresponsive host
+var remoteKillOptions = []string{"BatchMode=yes", "ConnectTimeout=3"}
+
+// NewSSHCmd initializes SSHCmd with defaults and sets the target host,
+// given as host, user@host, host:port or user@[ipv6]:port
 func NewSSHCmd(host string) *SSHCmd {
 	ssh := &SSHCmd{
-		Host:          host,
-		SSHExecutable: "ssh",
+		Host:            host,
+		SSHExecutable:   "ssh",
+		SCPExecutable:   "scp",
+		RsyncExecutable: "rsync",
 	}
 
 	ssh.Cmd = NewCmd()
 	ssh.Cmd.PrefixStdout = color(host) + " "
 	ssh.Cmd.PrefixStderr = color(host) + colorErr("@err ")
 
-	// Path to ssh binary could be overridden by setting `SSH_EXECUTABLE` env variable
+	// Paths to ssh, scp and rsync binaries could be overridden by setting
+	// `SSH_EXECUTABLE`, `SCP_EXECUTABLE` and `RSYNC_EXECUTABLE` env variables
 	if sshEnvExec, ok := os.LookupEnv("SSH_EXECUTABLE"); ok {
 		ssh.SSHExecutable = sshEnvExec
 	}
-
-	// User detect from user@host
-	if arr := strings.Split(host, "@"); len(arr) == 2 {
-		ssh.User = arr[0]
-		ssh.Host = arr[1]
+	if scpEnvExec, ok := os.LookupEnv("SCP_EXECUTABLE"); ok {
+		ssh.SCPExecutable = scpEnvExec
+	}
+	if rsyncEnvExec, ok := os.LookupEnv("RSYNC_EXECUTABLE"); ok {
+		ssh.RsyncExecutable = rsyncEnvExec
 	}
 
+	// User and port detect from user@host:port
+	user, hostname, port := splitHostAddr(host)
+	ssh.User = user
+	ssh.Host = hostname
+	ssh.Port = port
+
 	return ssh
 }
 
-// Wait wraps Cmd.Wait(), waiting for the remote command to complete
+// Wait wraps Cmd.Wait(), waiting for the remote command to complete.
+// Errors of ssh itself, like an unreachable host or an authentication failure, are returned as *SSHError.
 func (s *SSHCmd) Wait() error {
 	return s.Cmd.Wait()
 }
 
 // Run wraps Cmd.Run(), executing the remote command and waiting for it to complete
 func (s *SSHCmd) Run(command string, timeout ...time.Duration) (res CmdRes, err error) {
-	if res, err = s.Start(command, timeout...); err != nil {
+	return s.RunContext(context.Background(), command, timeout...)
+}
+
+// RunContext runs the remote command like .Run(), the command is killed when ctx is done.
+func (s *SSHCmd) RunContext(ctx context.Context, command string, timeout ...time.Duration) (res CmdRes, err error) {
+	proc, err := s.start(ctx, command, timeout...)
+	s.Cmd.setProcess(proc)
+	if proc != nil {
+		res = proc.Res
+	}
+	if err != nil {
 		return
 	}
 
-	err = s.Wait()
-	return
+	err = proc.Wait()
+	return proc.Res, err
 }
 
 // Start wraps Cmd.Start() with ssh invocation, starting the remote command
 func (s *SSHCmd) Start(command string, timeout ...time.Duration) (res CmdRes, err error) {
+	proc, err := s.start(context.Background(), command, timeout...)
+	s.Cmd.setProcess(proc)
+	if proc != nil {
+		res = proc.Res
+	}
+	return
+}
+
+// StartProcess starts the remote command like .Start(), but returns its own Process to wait for,
+// so the same SSHCmd could run several commands concurrently. The process is nil on error.
+func (s *SSHCmd) StartProcess(command string, timeout ...time.Duration) (*Process, error) {
+	proc, err := s.start(context.Background(), command, timeout...)
+	if err != nil {
+		return nil, err
+	}
+
+	return proc, nil
+}
+
+// start wraps the command with ssh invocation and starts it, the ssh process is killed when ctx is done.
+// The returned process is nil if the ssh command can't be prepared.
+func (s *SSHCmd) start(ctx context.Context, command string, timeout ...time.Duration) (*Process, error) {
+	return s.startWithIO(ctx, command, processIO{}, timeout...)
+}
+
+// processIO connects the remote command to the caller
+type processIO struct {
+	// stdin is fed to the remote command, such commands never run in a pseudo-terminal
+	stdin io.Reader
+	// observe gets the command output as it's written
+	observe func(data []byte)
+	// redact lists secrets masked in the output
+	redact []string
+	// mapErr replaces the command error after ssh errors are classified
+	mapErr func(err error) error
+}
+
+// startOptions returns default start options of the local ssh, scp or rsync command with the host of output events.
+func (s *SSHCmd) startOptions() startOptions {
+	opts := s.Cmd.startOptions()
+	// the local become of Cmd would wrap the ssh, scp or rsync invocation, .Become wraps the remote command
+	opts.become = nil
+	opts.host = s.Host
+	opts.sshUser = s.User
+	opts.becomeUser = ""
+	if s.Become != nil {
+		opts.becomeUser = s.Become.user()
+	}
+	if s.Recorder != nil {
+		opts.recorder = s.Recorder
+	}
+	if s.Audit != nil {
+		opts.audit = s.Audit
+	}
+	return opts
+}
+
+// startWithIO starts the command like .start(), connecting its input and output to pio.
+func (s *SSHCmd) startWithIO(ctx context.Context, command string, pio processIO, timeout ...time.Duration) (*Process, error) {
+	stdin := pio.stdin
 	if s.Host == "" {
-		err = fmt.Errorf("no host to run ssh command")
-		return
+		return nil, fmt.Errorf("no host to run ssh command")
 	}
 
-	sshArgs, err := s.warpInSSH(command)
+	remote, err := s.remoteCommand(command)
 	if err != nil {
-		return res, fmt.Errorf("failed to prepare ssh command: %w", err)
+		return nil, err
 	}
+	tty := stdin == nil && s.Interactive
 
-	res, err = s.Cmd.Start(strings.Join(sshArgs, " "), timeout...)
-	return
+	opts := s.startOptions()
+
+	if s.Become != nil {
+		// su and doas read the password from the remote pseudo-terminal, which stdin is written to
+		if s.Become.needsTTY() && !s.Interactive {
+			if stdin != nil {
+				return nil, errBecomeTTY
+			}
+			tty = true
+		}
+
+		var password io.Reader
+		remote, password = s.Become.wrap(remote, !s.Interactive)
+		stdin = withStdin(password, stdin)
+		opts.redact = s.Become.secrets()
+	}
+	opts.redact = append(opts.redact, pio.redact...)
+	opts.observe = pio.observe
+	opts.auditCommand, opts.auditCwd = command, s.Cwd
+
+	sshArgs, err := s.sshCommand(remote, tty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare ssh command: %w", err)
+	}
+
+	opts.interactive = stdin == nil && (opts.interactive || tty)
+	opts.mapErr = s.classifySSHError
+	if pio.mapErr != nil {
+		opts.mapErr = func(err error, stderrTail []string) error {
+			return pio.mapErr(s.classifySSHError(err, stderrTail))
+		}
+	}
+	opts.stdin = stdin
+
+	if s.KillRemote && !tty {
+		id, err := newTrackingID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare ssh command: %w", err)
+		}
+		pidFile := "/tmp/.execmd-" + id + ".pid"
+		// ssh runs the local command once it has connected, so the host is killed only if it was reached
+		connected := filepath.Join(os.TempDir(), ".execmd-"+id+".connected")
+
+		killer := *s
+		killer.Options = append(append([]string{}, s.Options...), remoteKillOptions...)
+		killArgs, err := killer.sshCommand(killRemotePid(pidFile), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare ssh command: %w", err)
+		}
+
+		// display the command as is, without pid tracking
+		opts.display = strings.Join(sshArgs, " ")
+		shellPath := s.Cmd.ShellPath
+		opts.onKill = func() {
+			if _, err := os.Stat(connected); err == nil {
+				go killRemote(shellPath, killArgs)
+			}
+		}
+		opts.onExit = func() {
+			os.Remove(connected)
+		}
+
+		tracked := *s
+		tracked.Options = append(append([]string{}, s.Options...), "PermitLocalCommand=yes", "LocalCommand=touch "+shellQuote(connected))
+		sshArgs, err = tracked.sshCommand(trackRemotePid(pidFile)+remote, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare ssh command: %w", err)
+		}
+	}
+
+	return s.Cmd.start(ctx, strings.Join(sshArgs, " "), opts, timeout...)
 }
 
-// warpInSSH takes a command string and returns an ssh-compatible argument slice
-func (s *SSHCmd) warpInSSH(command string) ([]string, error) {
-	sshArgs := []string{s.SSHExecutable}
-	hostWithUser := s.Host
-	if s.User != "" {
-		hostWithUser = s.User + "@" + s.Host
+// muted returns a copy of SSHCmd with a new Cmd printing nothing, for internal helper commands
+func (s *SSHCmd) muted() *SSHCmd {
+	m := *s
+	m.Cmd = NewCmd()
+	m.Cmd.ShellPath = s.Cmd.ShellPath
+	m.Cmd.MuteCmd = true
+	m.Cmd.MuteStdout = true
+	m.Cmd.MuteStderr = true
+	// internal commands are not audited
+	m.Audit = nil
+
+	return &m
+}
+
+// killRemote runs the ssh command killing the remote process group, see killRemotePid
+func killRemote(shellPath string, killArgs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteKillTimeout)
+	defer cancel()
+
+	return exec.CommandContext(ctx, shellPath, "-c", strings.Join(killArgs, " ")).Run()
+}
+
+// newTrackingID returns a unique id of the remote pid file and the local connection marker
+func newTrackingID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
 	}
 
-	sshArgs = append(sshArgs, hostWithUser)
+	return hex.EncodeToString(id), nil
+}
+
+// trackRemotePid returns a shell prefix saving the remote shell pid, which sshd makes a process group leader,
+// to the pid file and removing the file on exit. A read-only /tmp only leaves the command unkillable.
+func trackRemotePid(pidFile string) string {
+	return "trap 'rm -f " + pidFile + "' EXIT; { echo $$ > " + pidFile + "; } 2>/dev/null; "
+}
+
+// killRemotePid returns a shell command killing the process group saved to the pid file,
+// with SIGTERM first and SIGKILL if the group is still alive
+func killRemotePid(pidFile string) string {
+	return "[ -f " + pidFile + " ] || exit 0; pgid=$(cat " + pidFile + "); " +
+		"kill -s TERM -- -$pgid; " +
+		"for i in 1 2 3 4 5; do kill -s 0 -- -$pgid || break; sleep 0.2; done; " +
+		"kill -s KILL -- -$pgid; rm -f " + pidFile
+}
 
-	if s.Interactive || strings.Contains(command, "sudo") {
+// remoteCommand prepends the command with changing the working dir and exporting the environment
+func (s *SSHCmd) remoteCommand(command string) (string, error) {
+	if s.Cwd != "" {
+		command = "cd " + quoteDir(s.Cwd) + " && " + command
+	}
+	if len(s.Env) > 0 {
+		export, err := exportEnv(s.Env)
+		if err != nil {
+			return "", err
+		}
+		command = export + command
+	}
+
+	return command, nil
+}
+
+// sshCommand returns an ssh argument slice running the remote command as is
+func (s *SSHCmd) sshCommand(remote string, tty bool) ([]string, error) {
+	sshArgs := []string{s.SSHExecutable, s.userHost()}
+
+	if tty {
 		sshArgs = append(sshArgs, "-tt")
-		s.Cmd.Interactive = true
 	}
+
+	connArgs, err := s.connectionArgs("-p")
+	if err != nil {
+		return nil, err
+	}
+	sshArgs = append(sshArgs, connArgs...)
+
+	sshArgs = append(sshArgs, shellQuote(remote))
+	return sshArgs, nil
+}
+
+// userHost returns the ssh destination as user@host
+func (s *SSHCmd) userHost() string {
+	if s.User != "" {
+		return s.User + "@" + s.Host
+	}
+	return s.Host
+}
+
+// connectionArgs returns the port, key, jump host and options arguments shared by ssh and scp,
+// portFlag is -p for ssh and -P for scp
+func (s *SSHCmd) connectionArgs(portFlag string) ([]string, error) {
+	var args []string
 	if s.Port != "" {
-		sshArgs = append(sshArgs, "-p", s.Port)
+		args = append(args, portFlag, s.Port)
 	}
 	if s.KeyPath != "" {
 		if _, err := os.Stat(s.KeyPath); err != nil {
 			return nil, fmt.Errorf("ssh key not found at path %s: %w", s.KeyPath, err)
 		}
-		sshArgs = append(sshArgs, "-i", s.KeyPath)
+		args = append(args, "-i", s.KeyPath)
 	}
-	if s.Cwd != "" {
-		command = "cd " + s.Cwd + " && " + command
+	if s.JumpHost != "" {
+		args = append(args, "-J", s.JumpHost)
+	}
+	for _, opt := range s.Options {
+		args = append(args, "-o", shellQuote(opt))
 	}
 
-	// escape single quotes for shell encapsulation
-	sshArgs = append(sshArgs, "'"+strings.Replace(command, "'", "'\\''", -1)+"'")
-	return sshArgs, nil
+	return args, nil
+}
+
+// envName matches valid names of environment variables, other names would inject shell code into export
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// exportEnv returns a shell statement exporting the environment variables in a stable order
+func exportEnv(env map[string]string) (string, error) {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		if !envName.MatchString(name) {
+			return "", fmt.Errorf("invalid environment variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := make([]string, len(names))
+	for i, name := range names {
+		vars[i] = name + "=" + shellQuote(env[name])
+	}
+
+	return "export " + strings.Join(vars, " ") + "; ", nil
+}
+
+// quoteDir quotes the remote directory for the shell, keeping the home directory prefix ~/ expanded
+func quoteDir(dir string) string {
+	if dir == "~" {
+		return dir
+	}
+	if strings.HasPrefix(dir, "~/") {
+		return "~/" + shellQuote(dir[2:])
+	}
+	return shellQuote(dir)
+}
+
+// shellQuote escapes single quotes for shell encapsulation
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", "'\\''", -1) + "'"
 }
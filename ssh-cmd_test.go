@@ -3,7 +3,11 @@
 package execmd_test
 
 import (
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -63,6 +67,16 @@ func TestNewSSHCmd_Cwd(t *testing.T) {
 	if res.Stdout.String() != "/tmp\n" {
 		t.Errorf("no working dir change")
 	}
+
+	// the dir is not interpreted by the shell
+	dir := filepath.Join(t.TempDir(), "my dir; $HOME")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	srv.Cwd = dir
+	if res, err := srv.Run("pwd"); err != nil || res.Stdout.String() != dir+"\n" {
+		t.Errorf("Unexpected working dir with spaces: %q, %v", res.Stdout, err)
+	}
 }
 
 func TestNewSSHCmd_CwdNonExisting(t *testing.T) {
@@ -76,3 +90,101 @@ func TestNewSSHCmd_CwdNonExisting(t *testing.T) {
 		t.Error("no error when nonexisting working dir change")
 	}
 }
+
+func TestNewSSHCmd_HostAddr(t *testing.T) {
+	tests := []struct {
+		addr, user, host, port string
+	}{
+		{"web01", "", "web01", ""},
+		{"root@web01", "root", "web01", ""},
+		{"root@web01:2222", "root", "web01", "2222"},
+		{"web01:22", "", "web01", "22"},
+		{"::1", "", "::1", ""},
+		{"admin@[2001:db8::1]:2200", "admin", "2001:db8::1", "2200"},
+		{"[fe80::1]", "", "fe80::1", ""},
+	}
+
+	for _, tt := range tests {
+		ssh := execmd.NewSSHCmd(tt.addr)
+		if ssh.User != tt.user || ssh.Host != tt.host || ssh.Port != tt.port {
+			t.Errorf("NewSSHCmd(%s): got user=%q host=%q port=%q", tt.addr, ssh.User, ssh.Host, ssh.Port)
+		}
+	}
+}
+
+func TestNewSSHCmd_Env(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+	srv.Env = map[string]string{"GREETING": "it's me", "NAME": "world"}
+	res, err := srv.Run("echo $GREETING $NAME")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout.String() != "it's me world\n" {
+		t.Errorf("Unexpected output: %s", res.Stdout)
+	}
+
+	srv.Env = map[string]string{"A;touch pwned": "1"}
+	if _, err := srv.Run("true"); err == nil || !strings.Contains(err.Error(), "invalid environment variable name") {
+		t.Errorf("Expected invalid name error, got: %v", err)
+	}
+}
+
+func TestSSHCmd_ConcurrentRun(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			expected := strconv.Itoa(i) + "\n"
+			res, err := srv.Run("echo "+strconv.Itoa(i), 3*time.Second)
+			if err != nil {
+				t.Errorf("Failed to run command: %v", err)
+				return
+			}
+			if res.Stdout.String() != expected {
+				t.Errorf("Unexpected output: %s, expected %s", res.Stdout, expected)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestNewSSHCmd_TimeoutKillsRemote(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+	srv.KillRemote = true
+	if _, err := srv.Run("sleep 31.4159; echo OK", time.Second); err == nil {
+		t.Fatal("Expected a timeout error, but got nil")
+	}
+
+	// the remote command is killed in the background, the brackets keep pgrep from matching its own command line
+	var res execmd.CmdRes
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		var err error
+		if res, err = srv.Run("pgrep -f 'sleep 31[.]4159'"); err != nil {
+			return
+		}
+	}
+	t.Errorf("Remote command is still running after timeout: %s", res.Stdout)
+}
+
+func TestNewSSHCmd_TimeoutOfUnresponsiveHost(t *testing.T) {
+	// an ssh which never connects
+	ssh := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(ssh, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := execmd.NewSSHCmd(dummyHost)
+	srv.SSHExecutable = ssh
+	srv.KillRemote = true
+	started := time.Now()
+	if _, err := srv.Run("uptime", 500*time.Millisecond); err == nil {
+		t.Error("Expected a timeout error, but got nil")
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("Timeout of unresponsive host took %s", time.Since(started))
+	}
+}
@@ -0,0 +1,139 @@
+package execmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SSHErrorKind is a class of ssh transport failures.
+type SSHErrorKind string
+
+// Kinds of ssh transport failures recognized by the ssh error output.
+const (
+	SSHErrDNS         SSHErrorKind = "dns"
+	SSHErrRefused     SSHErrorKind = "connection refused"
+	SSHErrTimeout     SSHErrorKind = "connection timeout"
+	SSHErrUnreachable SSHErrorKind = "network unreachable"
+	SSHErrAuth        SSHErrorKind = "authentication failure"
+	SSHErrHostKey     SSHErrorKind = "host key mismatch"
+	SSHErrConnection  SSHErrorKind = "connection failure"
+)
+
+// sshExitCode is the OpenSSH exit status for its own errors
+const sshExitCode = 255
+
+// sshErrorPatterns maps the diagnostics ssh prints about the host to their kinds, %s is the host name,
+// the first match wins. The lines start with the pattern, or with the user name if withUser is set,
+// so the output of the remote command and messages about other hosts don't match.
+var sshErrorPatterns = []struct {
+	format   string
+	withUser bool
+	kind     SSHErrorKind
+}{
+	{"ssh: Could not resolve hostname %s: ", false, SSHErrDNS},
+	{"ssh: connect to host %s port ", false, SSHErrConnection},
+	{"@%s: Permission denied (", true, SSHErrAuth},
+}
+
+// sshConnectReasons are the kinds of "ssh: connect to host" failures by the reason at the end of the line
+var sshConnectReasons = []struct {
+	reason string
+	kind   SSHErrorKind
+}{
+	{": Connection refused", SSHErrRefused},
+	{": Connection timed out", SSHErrTimeout},
+	{": Operation timed out", SSHErrTimeout},
+	{": No route to host", SSHErrUnreachable},
+	{": Network is unreachable", SSHErrUnreachable},
+}
+
+// sshHostKeyFailed is printed by ssh when the host key is unknown or has changed
+const sshHostKeyFailed = "Host key verification failed."
+
+// SSHError is an ssh transport failure, as opposed to a failure of the remote command:
+// the host is unreachable, rejects the connection or the authentication.
+type SSHError struct {
+	Kind SSHErrorKind
+	// Message is the ssh error output line
+	Message string
+	Err     error
+}
+
+func (e *SSHError) Error() string {
+	return fmt.Sprintf("ssh %s: %s", e.Kind, e.Message)
+}
+
+func (e *SSHError) Unwrap() error {
+	return e.Err
+}
+
+// AsSSHError returns the ssh transport failure wrapped in err, or nil if err is a remote command error.
+func AsSSHError(err error) *SSHError {
+	var sshErr *SSHError
+	if errors.As(err, &sshErr) {
+		return sshErr
+	}
+	return nil
+}
+
+// classifySSHError wraps err into SSHError if ssh exited with its own error status
+// and the stderr output has an ssh diagnostic about the host, otherwise err is a remote command error.
+func classifySSHError(host string, err error, stderrTail []string) error {
+	if exitCode(err) != sshExitCode {
+		return err
+	}
+
+	return matchSSHError(host, err, stderrTail)
+}
+
+// matchSSHError wraps err into SSHError if the stderr output has an ssh diagnostic about the host
+func matchSSHError(host string, err error, stderrTail []string) error {
+	for _, line := range stderrTail {
+		line = strings.TrimSpace(line)
+		if line == sshHostKeyFailed {
+			return &SSHError{Kind: SSHErrHostKey, Message: line, Err: err}
+		}
+	}
+
+	for _, p := range sshErrorPatterns {
+		pattern := fmt.Sprintf(p.format, host)
+		for _, line := range stderrTail {
+			line = strings.TrimSpace(line)
+			i := strings.Index(line, pattern)
+			if i < 0 || (i > 0) != p.withUser || strings.ContainsAny(line[:i], " \t") {
+				continue
+			}
+
+			kind := p.kind
+			if kind == SSHErrConnection {
+				for _, r := range sshConnectReasons {
+					if strings.HasSuffix(line, r.reason) {
+						kind = r.kind
+						break
+					}
+				}
+			}
+			return &SSHError{Kind: kind, Message: line, Err: err}
+		}
+	}
+
+	return err
+}
+
+// classifySSHError classifies the ssh failures of the host, see classifySSHError.
+func (s *SSHCmd) classifySSHError(err error, stderrTail []string) error {
+	return classifySSHError(s.Host, err, stderrTail)
+}
+
+// scpExitCode is the scp exit status for all its errors, including the failures of ssh it runs
+const scpExitCode = 1
+
+// classifySCPError classifies the ssh failures of the host in scp output, scp exits with its own
+// error status if the ssh transport fails, or with the ssh status if a remote command after scp fails.
+func (s *SSHCmd) classifySCPError(err error, stderrTail []string) error {
+	if code := exitCode(err); code != scpExitCode && code != sshExitCode {
+		return err
+	}
+	return matchSSHError(s.Host, err, stderrTail)
+}
@@ -0,0 +1,81 @@
+package execmd_test
+
+import (
+	"errors"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestNewSSHCmd_SSHError(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+	res, err := srv.Run("exit 255")
+	if err == nil {
+		t.Fatal("Expected error, but got nil")
+	}
+	if execmd.AsSSHError(err) != nil {
+		t.Errorf("Remote exit 255 is classified as ssh error: %v", err)
+	}
+	if res.ExitCode != 255 {
+		t.Errorf("Unexpected exit code: %d", res.ExitCode)
+	}
+
+	srv = execmd.NewSSHCmd("no-such-host.invalid")
+	res, err = srv.Run("true")
+	sshErr := execmd.AsSSHError(err)
+	if sshErr == nil {
+		t.Fatalf("Expected ssh error, got: %v", err)
+	}
+	if sshErr.Kind != execmd.SSHErrDNS {
+		t.Errorf("Unexpected ssh error kind: %s", sshErr.Kind)
+	}
+	if res.ExitCode != 255 {
+		t.Errorf("Unexpected exit code: %d", res.ExitCode)
+	}
+}
+
+func TestNewSSHCmd_SSHErrorOfOtherHost(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+	_, err := srv.Run(`echo "ssh: connect to host db01 port 22: Connection refused" >&2; ` +
+		`echo "Connection closed by 10.0.0.1 port 22" >&2; echo "ssh: fatal" >&2; exit 255`)
+	if err == nil {
+		t.Fatal("Expected error, but got nil")
+	}
+	if sshErr := execmd.AsSSHError(err); sshErr != nil {
+		t.Errorf("Remote output is classified as ssh error: %v", sshErr)
+	}
+
+	srv = execmd.NewSSHCmd("127.0.0.1:1")
+	_, err = srv.Run("true")
+	sshErr := execmd.AsSSHError(err)
+	if sshErr == nil {
+		t.Fatalf("Expected ssh error, got: %v", err)
+	}
+	if sshErr.Kind != execmd.SSHErrRefused {
+		t.Errorf("Unexpected ssh error kind: %s", sshErr.Kind)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	cluster := execmd.NewClusterSSHCmd(append(dummyHosts, "no-such-host.invalid"))
+
+	res, err := cluster.RunMap(map[string]string{
+		dummyHosts[0]:          "true",
+		dummyHosts[1]:          "exit 255",
+		"no-such-host.invalid": "true",
+	})
+	if err == nil {
+		t.Fatal("Expected error, but got nil")
+	}
+	if res[2].SSHErr == nil || !errors.Is(res[2].Err, res[2].SSHErr) {
+		t.Errorf("Expected ssh error on host %s, got: %v", res[2].Host, res[2].Err)
+	}
+
+	summary := execmd.Summarize(res)
+	if len(summary.OK) != 1 || len(summary.Failed) != 1 || len(summary.Unreachable) != 1 {
+		t.Errorf("Unexpected summary: %s", summary)
+	}
+	if summary.Unreachable[0] != "no-such-host.invalid" {
+		t.Errorf("Unexpected unreachable hosts: %v", summary.Unreachable)
+	}
+}
@@ -16,8 +16,20 @@ type prefixedStream struct {
 	data     *bytes.Buffer
 	prefix   string
 	saveData bool
+	tail     []string
+	// redact lists secrets replaced in the output, e.g. passwords echoed by a terminal
+	redact []string
+	// observe gets the raw output as it's written, including incomplete lines
+	observe func(data []byte)
+	// encode formats the output lines instead of the prefix, e.g. as JSON events
+	encode func(line string) string
+	// record gets the output lines as they are displayed, with the prefix
+	record func(text string)
 }
 
+// tailSize is the number of last lines kept by prefixedStream even if saveData is false
+const tailSize = 10
+
 // newPrefixedStream creates a new PrefixedStream with the provided logger,
 // prefix, and a flag indicating whether to save the output data.
 func newPrefixedStream(logger *log.Logger, prefix string, saveData bool) *prefixedStream {
@@ -33,6 +45,10 @@ func newPrefixedStream(logger *log.Logger, prefix string, saveData bool) *prefix
 // Write writes data to the buffer and processes any complete lines,
 // adding the prefix and logging them.
 func (p *prefixedStream) Write(data []byte) (int, error) {
+	if p.observe != nil {
+		p.observe(data)
+	}
+
 	n, err := p.buffer.Write(data)
 	if err != nil {
 		return n, err
@@ -56,16 +72,11 @@ func (p *prefixedStream) Close() error {
 	return nil
 }
 
-// flush reads the remaining data from the buffer, processes it,
+// flush processes the incomplete line left in the buffer, if any,
 // and logs it with the prefix.
 func (p *prefixedStream) flush() error {
-	var data []byte
-
-	if _, err := p.buffer.Read(data); err != nil {
-		return err
-	}
-
-	p.output(string(data))
+	p.output(p.buffer.String())
+	p.buffer.Reset()
 	return nil
 }
 
@@ -97,6 +108,11 @@ func (p *prefixedStream) outputLines() error {
 	return nil
 }
 
+// Tail returns the last lines of output without line endings.
+func (p *prefixedStream) Tail() []string {
+	return p.tail
+}
+
 // ClearData resets the data buffer.
 func (p *prefixedStream) ClearData() {
 	p.data.Reset()
@@ -109,11 +125,27 @@ func (p *prefixedStream) output(text string) {
 		return
 	}
 
+	for _, secret := range p.redact {
+		text = strings.Replace(text, secret, redactedText, -1)
+	}
+
 	if p.saveData {
 		p.data.WriteString(text)
 	}
 
-	text = p.prefix + text
+	if len(p.tail) == tailSize {
+		p.tail = p.tail[1:]
+	}
+	p.tail = append(p.tail, strings.TrimRight(text, "\r\n"))
+
+	if p.encode != nil {
+		text = p.encode(text)
+	} else {
+		text = p.prefix + text
+	}
 
 	p.Logger.Print(text)
+	if p.record != nil {
+		p.record(ensureNewline(text))
+	}
 }
@@ -0,0 +1,95 @@
+package execmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ClusterSummary groups cluster hosts by the result: hosts which failed on ssh transport level
+// are unreachable, hosts where the remote command failed are failed, see ClusterRes.Status.
+type ClusterSummary struct {
+	OK          []string
+	Failed      []string
+	Unreachable []string
+	Timeout     []string
+	Skipped     []string
+}
+
+// ResultStatus is the outcome of a command on a host.
+type ResultStatus string
+
+// Outcomes of commands, see ClusterRes.Status.
+const (
+	StatusOK          ResultStatus = "ok"
+	StatusFailed      ResultStatus = "failed"
+	StatusUnreachable ResultStatus = "unreachable"
+	StatusTimeout     ResultStatus = "timeout"
+	StatusSkipped     ResultStatus = "skipped"
+)
+
+// Status returns the outcome of the command on the host: skipped if it was not started,
+// unreachable if it failed on ssh transport level, timeout if it was killed on the host
+// or the cluster timeout, failed if the command failed.
+func (r ClusterRes) Status() ResultStatus {
+	if r.Skipped {
+		return StatusSkipped
+	}
+	return resultStatus(r.Res, r.Err, r.SSHErr)
+}
+
+// resultStatus classifies the command result, sshErr is the ssh failure if it's known already
+func resultStatus(res CmdRes, err error, sshErr *SSHError) ResultStatus {
+	switch {
+	case err == nil:
+		return StatusOK
+	case sshErr != nil || AsSSHError(err) != nil:
+		return StatusUnreachable
+	case res.TimedOut || errors.Is(err, ErrClusterTimeout):
+		return StatusTimeout
+	default:
+		return StatusFailed
+	}
+}
+
+// Summarize groups hosts of the cluster results by the result.
+func Summarize(results []ClusterRes) ClusterSummary {
+	var s ClusterSummary
+	for _, r := range results {
+		switch r.Status() {
+		case StatusOK:
+			s.OK = append(s.OK, r.Host)
+		case StatusUnreachable:
+			s.Unreachable = append(s.Unreachable, r.Host)
+		case StatusTimeout:
+			s.Timeout = append(s.Timeout, r.Host)
+		case StatusSkipped:
+			s.Skipped = append(s.Skipped, r.Host)
+		default:
+			s.Failed = append(s.Failed, r.Host)
+		}
+	}
+
+	return s
+}
+
+// String returns the summary with folded host names, e.g. "ok: 3 web[01-03]; failed: 1 web04".
+func (s ClusterSummary) String() string {
+	var parts []string
+	for _, group := range []struct {
+		name  string
+		hosts []string
+	}{
+		{"ok", s.OK},
+		{"failed", s.Failed},
+		{"unreachable", s.Unreachable},
+		{"timeout", s.Timeout},
+		{"skipped", s.Skipped},
+	} {
+		if len(group.hosts) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d %s", group.name, len(group.hosts), FoldHosts(group.hosts)))
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
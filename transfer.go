@@ -0,0 +1,180 @@
+package execmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TransferOptions customize file transfers with .Upload() and .Download().
+type TransferOptions struct {
+	// Recursive copies directories, uploads of local directories are always recursive
+	Recursive bool
+	// Preserve keeps modification times and modes of the source files
+	Preserve bool
+	// Owner is set on the uploaded files with chown, e.g. "www-data:www-data"
+	Owner string
+	// Mode is set on the uploaded files with chmod, e.g. "0640" or "u=rw,go=r"
+	Mode string
+	// Timeout limits the whole transfer
+	Timeout time.Duration
+}
+
+// Upload copies the local file or directory to the remote path with scp,
+// the host connection settings (user, port, key, jump host and options) are reused.
+func (s *SSHCmd) Upload(localPath, remotePath string, opts ...TransferOptions) (CmdRes, error) {
+	return s.transfer(s.startUpload(context.Background(), localPath, remotePath, transferOptions(opts)))
+}
+
+// Download copies the remote file, or the directory with opts.Recursive, to the local path with scp.
+func (s *SSHCmd) Download(remotePath, localPath string, opts ...TransferOptions) (CmdRes, error) {
+	return s.transfer(s.startDownload(context.Background(), remotePath, localPath, transferOptions(opts)))
+}
+
+// transfer waits for the started transfer process and returns its result
+func (s *SSHCmd) transfer(proc *Process, err error) (CmdRes, error) {
+	if proc == nil {
+		return CmdRes{}, err
+	}
+	if err != nil {
+		return proc.Res, err
+	}
+
+	err = proc.Wait()
+	return proc.Res, err
+}
+
+func transferOptions(opts []TransferOptions) TransferOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return TransferOptions{}
+}
+
+// startUpload starts scp uploading the local path, followed by chown and chmod on the host if requested.
+// The returned process is nil if the command can't be prepared.
+func (s *SSHCmd) startUpload(ctx context.Context, localPath, remotePath string, opts TransferOptions) (*Process, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload: %w", err)
+	}
+	opts.Recursive = opts.Recursive || info.IsDir()
+
+	scpArgs, err := s.scpCommand(shellQuote(localPath), shellQuote(s.remoteTarget(remotePath)), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare scp command: %w", err)
+	}
+	command := strings.Join(scpArgs, " ")
+
+	var remote []string
+	if opts.Owner != "" {
+		remote = append(remote, "chown "+recursiveFlag(opts)+shellQuote(opts.Owner)+" "+shellQuote(remotePath))
+	}
+	if opts.Mode != "" {
+		remote = append(remote, "chmod "+recursiveFlag(opts)+shellQuote(opts.Mode)+" "+shellQuote(remotePath))
+	}
+	if len(remote) > 0 {
+		sshArgs, err := s.sshCommand(strings.Join(remote, " && "), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare ssh command: %w", err)
+		}
+		command += " && " + strings.Join(sshArgs, " ")
+	}
+
+	return s.Cmd.start(ctx, command, s.scpOptions(), opts.Timeout)
+}
+
+// startDownload starts scp downloading the remote path, local parent directories are created.
+// The returned process is nil if the command can't be prepared.
+func (s *SSHCmd) startDownload(ctx context.Context, remotePath, localPath string, opts TransferOptions) (*Process, error) {
+	if dir := filepath.Dir(localPath); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to download: %w", err)
+		}
+	}
+
+	scpArgs, err := s.scpCommand(shellQuote(s.remoteTarget(remotePath)), shellQuote(localPath), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare scp command: %w", err)
+	}
+
+	return s.Cmd.start(ctx, strings.Join(scpArgs, " "), s.scpOptions(), opts.Timeout)
+}
+
+// scpOptions returns the start options of scp transfers, the ssh failures are classified
+func (s *SSHCmd) scpOptions() startOptions {
+	opts := s.startOptions()
+	opts.mapErr = s.classifySCPError
+	return opts
+}
+
+// scpCommand returns an scp argument slice copying the already quoted source to the destination
+func (s *SSHCmd) scpCommand(src, dst string, opts TransferOptions) ([]string, error) {
+	scpArgs := []string{s.SCPExecutable, "-q"}
+	if opts.Recursive {
+		scpArgs = append(scpArgs, "-r")
+	}
+	if opts.Preserve {
+		scpArgs = append(scpArgs, "-p")
+	}
+
+	connArgs, err := s.connectionArgs("-P")
+	if err != nil {
+		return nil, err
+	}
+	scpArgs = append(scpArgs, connArgs...)
+
+	return append(scpArgs, src, dst), nil
+}
+
+// remoteTarget returns the remote path for scp and rsync as user@host:path, with IPv6 addresses in brackets
+func (s *SSHCmd) remoteTarget(remotePath string) string {
+	host := s.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if s.User != "" {
+		host = s.User + "@" + host
+	}
+
+	return host + ":" + remotePath
+}
+
+func recursiveFlag(opts TransferOptions) string {
+	if opts.Recursive {
+		return "-R "
+	}
+	return ""
+}
+
+// Upload copies the local file or directory to the remote path on all hosts in parallel.
+// It returns results and the first caught error.
+func (c *ClusterSSHCmd) Upload(localPath, remotePath string, opts ...TransferOptions) ([]ClusterRes, error) {
+	o := transferOptions(opts)
+	return c.runEach(func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error) {
+		return ssh.startUpload(ctx, localPath, remotePath, o)
+	})
+}
+
+// Download fetches the remote path from all hosts in parallel into per-host directories named
+// as the hosts are given, e.g. /etc/hosts from web01 and root@web02:2222 into localDir/web01/hosts
+// and localDir/root@web02:2222/hosts. Nothing is downloaded if a host is given twice.
+// It returns results and the first caught error.
+func (c *ClusterSSHCmd) Download(remotePath, localDir string, opts ...TransferOptions) ([]ClusterRes, error) {
+	seen := map[string]bool{}
+	for _, cmd := range c.Cmds {
+		if seen[cmd.Host] {
+			return nil, fmt.Errorf("failed to download: duplicate host %s", cmd.Host)
+		}
+		seen[cmd.Host] = true
+	}
+
+	o := transferOptions(opts)
+	return c.runEach(func(ctx context.Context, i int, ssh *SSHCmd) (*Process, error) {
+		localPath := filepath.Join(localDir, c.Cmds[i].Host, filepath.Base(remotePath))
+		return ssh.startDownload(ctx, remotePath, localPath, o)
+	})
+}
@@ -0,0 +1,104 @@
+package execmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+func TestSSHCmd_UploadDownload(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(local, []byte("listen 8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := execmd.NewSSHCmd(dummyHost)
+	remote := filepath.Join(dir, "remote.conf")
+	if _, err := srv.Upload(local, remote, execmd.TransferOptions{Mode: "0600"}); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+
+	info, err := os.Stat(remote)
+	if err != nil {
+		t.Fatalf("Uploaded file not found: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Unexpected mode of uploaded file: %s", info.Mode())
+	}
+
+	downloaded := filepath.Join(dir, "downloaded", "app.conf")
+	if _, err := srv.Download(remote, downloaded); err != nil {
+		t.Fatalf("Failed to download: %v", err)
+	}
+	if data, _ := os.ReadFile(downloaded); string(data) != "listen 8080\n" {
+		t.Errorf("Unexpected downloaded content: %q", data)
+	}
+
+	if _, err := srv.Upload(filepath.Join(dir, "i-am-not-exist"), remote); err == nil {
+		t.Error("Expected error on missing local file, but got nil")
+	}
+}
+
+func TestClusterSSHCmd_UploadDownload(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "release")
+	if err := os.MkdirAll(filepath.Join(release, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(release, "bin", "app"), []byte("v1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := execmd.NewClusterSSHCmd(dummyHosts)
+
+	// both dummy hosts are the local machine, so they share the remote path
+	remote := filepath.Join(dir, "deployed")
+	res, err := cluster.Upload(release, remote)
+	if err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	if len(res) != len(dummyHosts) {
+		t.Errorf("Unexpected number of results: %d", len(res))
+	}
+	if _, err := os.Stat(filepath.Join(remote, "bin", "app")); err != nil {
+		t.Errorf("Uploaded directory is not recursive: %v", err)
+	}
+
+	fetched := filepath.Join(dir, "fetched")
+	if _, err := cluster.Download(filepath.Join(release, "bin", "app"), fetched); err != nil {
+		t.Fatalf("Failed to download: %v", err)
+	}
+	for _, host := range dummyHosts {
+		if data, _ := os.ReadFile(filepath.Join(fetched, host, "app")); string(data) != "v1" {
+			t.Errorf("Unexpected downloaded content from host %s: %q", host, data)
+		}
+	}
+
+	cluster = execmd.NewClusterSSHCmd(append(dummyHosts, "unreachable-host"))
+	res, err = cluster.Download(filepath.Join(release, "bin", "app"), fetched)
+	if err == nil {
+		t.Error("Expected error on unreachable host, but got nil")
+	}
+	if res[len(dummyHosts)].SSHErr == nil {
+		t.Errorf("Expected ssh error on host %s, got: %v", res[len(dummyHosts)].Host, res[len(dummyHosts)].Err)
+	}
+
+	// the same host with another port or user gets its own directory
+	cluster = execmd.NewClusterSSHCmd([]string{dummyHost, dummyHost + ":22", "root@" + dummyHost})
+	if _, err := cluster.Download(filepath.Join(release, "bin", "app"), fetched); err != nil {
+		t.Fatalf("Failed to download: %v", err)
+	}
+	for _, cmd := range cluster.Cmds {
+		if data, _ := os.ReadFile(filepath.Join(fetched, cmd.Host, "app")); string(data) != "v1" {
+			t.Errorf("Unexpected downloaded content from host %s: %q", cmd.Host, data)
+		}
+	}
+
+	cluster = execmd.NewClusterSSHCmd([]string{dummyHost, dummyHost})
+	if _, err := cluster.Download(filepath.Join(release, "bin", "app"), fetched); err == nil {
+		t.Error("Expected error on duplicate hosts, but got nil")
+	}
+}
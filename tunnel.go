@@ -0,0 +1,226 @@
+package execmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTunnelReadyTimeout limits waiting for a tunnel to accept connections
+const DefaultTunnelReadyTimeout = 10 * time.Second
+
+// tunnelPollInterval is the delay between tunnel readiness checks
+const tunnelPollInterval = 100 * time.Millisecond
+
+// TunnelKind is the kind of ssh port forwarding.
+type TunnelKind string
+
+// Kinds of ssh port forwarding, by the ssh flag.
+const (
+	TunnelLocal   TunnelKind = "-L"
+	TunnelRemote  TunnelKind = "-R"
+	TunnelDynamic TunnelKind = "-D"
+)
+
+// ErrTunnelClosed is returned by Tunnel.Wait if the tunnel was up until it was closed with .Close()
+var ErrTunnelClosed = errors.New("tunnel closed")
+
+// Tunnel is an ssh port forwarding running in the background (ssh -N) until it is closed.
+type Tunnel struct {
+	Kind TunnelKind
+	// Spec is the ssh forwarding specification, e.g. 127.0.0.1:40211:db.internal:5432
+	Spec string
+	// Port is the listening port: local for local and dynamic forwards, remote for remote forwards
+	Port int
+
+	proc *Process
+	done chan struct{}
+	err  error
+	mu   sync.Mutex
+	// closed is set by .Close(), killed is set if ssh was still running when it was closed
+	closed bool
+	killed bool
+}
+
+// Addr returns the local address of local and dynamic forwards, e.g. 127.0.0.1:40211.
+func (t *Tunnel) Addr() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(t.Port))
+}
+
+// Close kills ssh and waits for it to exit, so the port is released when it returns.
+// It returns the error of ssh if the tunnel had failed before it was closed.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	t.proc.Cancel()
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.killed {
+		return nil
+	}
+	return t.err
+}
+
+// Wait blocks until the tunnel is down and returns the error of ssh,
+// or ErrTunnelClosed if the tunnel was up until it was closed with .Close().
+func (t *Tunnel) Wait() error {
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.killed {
+		return ErrTunnelClosed
+	}
+	return t.err
+}
+
+// LocalForward forwards the local port to the address as seen from the host (ssh -L),
+// e.g. LocalForward(0, "db.internal:5432"). A free local port is picked if localPort is 0.
+// It returns when the local port accepts connections.
+func (s *SSHCmd) LocalForward(localPort int, remoteAddr string, readyTimeout ...time.Duration) (*Tunnel, error) {
+	port, err := localTunnelPort(localPort)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := "127.0.0.1:" + strconv.Itoa(port) + ":" + remoteAddr
+	return s.startTunnel(TunnelLocal, spec, port, dialReady(port), readyTimeout...)
+}
+
+// DynamicForward starts a SOCKS proxy on the local port (ssh -D), connections are made from the host.
+// A free local port is picked if localPort is 0. It returns when the local port accepts connections.
+func (s *SSHCmd) DynamicForward(localPort int, readyTimeout ...time.Duration) (*Tunnel, error) {
+	port, err := localTunnelPort(localPort)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := "127.0.0.1:" + strconv.Itoa(port)
+	return s.startTunnel(TunnelDynamic, spec, port, dialReady(port), readyTimeout...)
+}
+
+// RemoteForward forwards the remote port on the host to the local address (ssh -R),
+// e.g. RemoteForward(8080, "127.0.0.1:3000").
+// It returns when the remote port accepts connections, which is checked on the host with bash /dev/tcp.
+func (s *SSHCmd) RemoteForward(remotePort int, localAddr string, readyTimeout ...time.Duration) (*Tunnel, error) {
+	if remotePort <= 0 {
+		return nil, fmt.Errorf("remote port is required for remote forward to %s", localAddr)
+	}
+
+	probe := s.muted()
+	check := "bash -c " + shellQuote("exec 3<>/dev/tcp/127.0.0.1/"+strconv.Itoa(remotePort))
+	ready := func() error {
+		_, err := probe.Run(check, tunnelPollInterval*10)
+		return err
+	}
+
+	spec := strconv.Itoa(remotePort) + ":" + localAddr
+	return s.startTunnel(TunnelRemote, spec, remotePort, ready, readyTimeout...)
+}
+
+// startTunnel starts ssh -N with the forwarding and polls ready until it succeeds,
+// the tunnel is closed if it's not ready in time.
+func (s *SSHCmd) startTunnel(kind TunnelKind, spec string, port int, ready func() error, readyTimeout ...time.Duration) (*Tunnel, error) {
+	timeout := DefaultTunnelReadyTimeout
+	if len(readyTimeout) > 0 && readyTimeout[0] > 0 {
+		timeout = readyTimeout[0]
+	}
+
+	connArgs, err := s.connectionArgs("-p")
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare ssh command: %w", err)
+	}
+
+	sshArgs := []string{s.SSHExecutable, s.userHost(), "-N", "-o", "ExitOnForwardFailure=yes"}
+	sshArgs = append(sshArgs, connArgs...)
+	sshArgs = append(sshArgs, string(kind), shellQuote(spec))
+
+	// the tunnel never reads stdin, so it always runs in the background
+	opts := s.startOptions()
+	opts.interactive = false
+	opts.mapErr = s.classifySSHError
+
+	proc, err := s.Cmd.start(context.Background(), strings.Join(sshArgs, " "), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start tunnel %s %s: %w", kind, spec, err)
+	}
+
+	t := &Tunnel{Kind: kind, Spec: spec, Port: port, proc: proc, done: make(chan struct{})}
+	go func() {
+		err := proc.Wait()
+
+		t.mu.Lock()
+		t.err = err
+		t.killed = t.closed && proc.interrupted
+		t.mu.Unlock()
+		close(t.done)
+	}()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		if err := ready(); err == nil {
+			return t, nil
+		}
+
+		select {
+		case <-t.done:
+			return nil, fmt.Errorf("tunnel %s %s failed: %w", kind, spec, t.Wait())
+		case <-deadline.C:
+			t.Close()
+			return nil, fmt.Errorf("tunnel %s %s is not ready after %s", kind, spec, timeout)
+		case <-time.After(tunnelPollInterval):
+		}
+	}
+}
+
+// localTunnelPort returns the port if it's free, or a free local port if it's 0.
+// A busy port would pass the readiness check before ssh fails to listen on it.
+func localTunnelPort(port int) (int, error) {
+	if port > 0 {
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			return 0, fmt.Errorf("local port %d is not available: %w", port, err)
+		}
+		return port, l.Close()
+	}
+
+	port, err := FreePort()
+	if err != nil {
+		return 0, fmt.Errorf("failed to pick a free local port: %w", err)
+	}
+	return port, nil
+}
+
+// FreePort returns a local TCP port which is free at the moment.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// dialReady returns a readiness check of the local port
+func dialReady(port int) func() error {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	return func() error {
+		conn, err := net.DialTimeout("tcp", addr, tunnelPollInterval*10)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
@@ -0,0 +1,122 @@
+package execmd_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	execmd "github.com/mikhae1/execmd"
+)
+
+// echoServer starts a local TCP server echoing every line back
+func echoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func assertEcho(t *testing.T, addr string) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel %s: %v", addr, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatalf("Failed to write to tunnel: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("Unexpected tunnel response: %q, %v", line, err)
+	}
+}
+
+func TestSSHCmd_LocalForward(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+
+	tunnel, err := srv.LocalForward(0, echoServer(t))
+	if err != nil {
+		t.Fatalf("Failed to start tunnel: %v", err)
+	}
+	if tunnel.Port == 0 {
+		t.Error("Free local port is not picked")
+	}
+
+	assertEcho(t, tunnel.Addr())
+
+	if err := tunnel.Close(); err != nil {
+		t.Errorf("Failed to close tunnel: %v", err)
+	}
+	if err := tunnel.Wait(); !errors.Is(err, execmd.ErrTunnelClosed) {
+		t.Errorf("Expected closed tunnel, got: %v", err)
+	}
+	if conn, err := net.DialTimeout("tcp", tunnel.Addr(), time.Second); err == nil {
+		conn.Close()
+		t.Error("Tunnel port still accepts connections after close")
+	}
+
+	// the port is taken by another tunnel
+	busy, err := srv.LocalForward(0, echoServer(t))
+	if err != nil {
+		t.Fatalf("Failed to start tunnel: %v", err)
+	}
+	defer busy.Close()
+
+	if _, err := srv.LocalForward(busy.Port, "127.0.0.1:1", 5*time.Second); err == nil {
+		t.Error("Expected error on busy local port, but got nil")
+	}
+}
+
+func TestSSHCmd_RemoteAndDynamicForward(t *testing.T) {
+	srv := execmd.NewSSHCmd(dummyHost)
+
+	port, err := execmd.FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel, err := srv.RemoteForward(port, echoServer(t))
+	if err != nil {
+		t.Fatalf("Failed to start remote tunnel: %v", err)
+	}
+	defer tunnel.Close()
+
+	// the dummy host is the local machine
+	assertEcho(t, "127.0.0.1:"+strconv.Itoa(port))
+
+	socks, err := srv.DynamicForward(0)
+	if err != nil {
+		t.Fatalf("Failed to start SOCKS tunnel: %v", err)
+	}
+	if socks.Kind != execmd.TunnelDynamic {
+		t.Errorf("Unexpected tunnel kind: %s", socks.Kind)
+	}
+	socks.Close()
+
+	if _, err := execmd.NewSSHCmd("127.0.0.1:1").DynamicForward(0); execmd.AsSSHError(err) == nil {
+		t.Errorf("Expected ssh error on unreachable host, got: %v", err)
+	}
+}